@@ -7,6 +7,10 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/nyaruka/rp-clover/models"
@@ -14,11 +18,46 @@ import (
 
 func newAdminRouter(s *Server) *chi.Mux {
 	router := chi.NewRouter()
+	canReadConfig := s.requirePermission(models.PermissionConfigRead)
+	canWriteConfig := s.requirePermission(models.PermissionConfigWrite)
+	canReadMappings := s.requirePermission(models.PermissionMappingsRead)
+	canWriteMappings := s.requirePermission(models.PermissionMappingsWrite)
+	canWriteUsers := s.requirePermission(models.PermissionUsersWrite)
+	canWriteTokens := s.requirePermission(models.PermissionTokensWrite)
+	canReadAudit := s.requirePermission(models.PermissionAuditRead)
+
+	router.Use(s.authenticate)
+	router.Group(func(r chi.Router) {
+		r.Use(standardMiddleware...)
+		r.With(canReadConfig).Get("/", s.newHandlerFunc(viewConfig))
+		r.With(canWriteConfig).Post("/", s.newHandlerFunc(updateConfig))
+
+		r.With(canReadMappings).Get("/{interchangeUUID:[0-9a-fA-F-]{36}}/map", s.newHandlerFunc(handleMap))
+		r.With(canWriteMappings).Post("/{interchangeUUID:[0-9a-fA-F-]{36}}/map", s.newHandlerFunc(handleMap))
+		r.With(canWriteMappings).Delete("/{interchangeUUID:[0-9a-fA-F-]{36}}/map", s.newHandlerFunc(handleMap))
+		r.With(canReadMappings).Get("/{interchangeUUID:[0-9a-fA-F-]{36}}/mappings", s.newHandlerFunc(handleListMappings))
+		r.With(canReadMappings).Get("/{interchangeUUID:[0-9a-fA-F-]{36}}/mappings/counts", s.newHandlerFunc(handleCountMappings))
+		r.With(canReadMappings).Post("/{interchangeUUID:[0-9a-fA-F-]{36}}/hash/report", s.newHandlerFunc(handleHashReport))
+
+		r.With(canWriteUsers).Get("/users", s.newHandlerFunc(handleListUsers))
+		r.With(canWriteUsers).Post("/users", s.newHandlerFunc(handleSaveUser))
+		r.With(canWriteUsers).Delete("/users/{username}", s.newHandlerFunc(handleDeleteUser))
+
+		r.With(canWriteTokens).Get("/tokens", s.newHandlerFunc(handleListTokens))
+		r.With(canWriteTokens).Post("/tokens", s.newHandlerFunc(handleCreateToken))
+		r.With(canWriteTokens).Delete("/tokens/{tokenID:[0-9]+}", s.newHandlerFunc(handleRevokeToken))
+
+		r.With(canReadAudit).Get("/audit", s.newHandlerFunc(viewAudit))
+
+		r.With(canReadConfig).Get("/stats", s.newHandlerFunc(handleStats))
+		r.With(canReadConfig).Get("/health", s.newHandlerFunc(handleHealth))
+		r.With(canReadConfig).Get("/metrics", s.newHandlerFunc(handleMetrics))
+		r.With(canReadConfig).Get("/health/{channelUUID:[0-9a-fA-F-]{36}}", s.newHandlerFunc(handleHealthHistory))
+	})
 
-	router.Use(s.adminOnly)
-	router.Method(http.MethodGet, "/", s.newHandlerFunc(viewConfig))
-	router.Method(http.MethodPost, "/", s.newHandlerFunc(updateConfig))
-	router.Mount("/{interchangeUUID:[0-9a-fA-F-]{36}}/map", s.newHandlerFunc(handleMap))
+	// exports can run for a long time, so they aren't subject to our standard timeout
+	router.With(canReadMappings).Get("/{interchangeUUID:[0-9a-fA-F-]{36}}/export", s.newHandlerFunc(handleExport))
+	router.With(canReadAudit).Get("/audit/export", s.newHandlerFunc(handleAuditExport))
 
 	return router
 }
@@ -34,10 +73,17 @@ func renderInterchanges(s *Server, w http.ResponseWriter, r *http.Request, confi
 		return err
 	}
 
+	capacities, err := channelCapacities(s, r)
+	if err != nil {
+		return err
+	}
+
 	err = tpl.Execute(w, map[string]interface{}{
-		"config":  string(config),
-		"message": message,
-		"error":   errMsg,
+		"config":     string(config),
+		"message":    message,
+		"error":      errMsg,
+		"breakers":   s.breakers.snapshot(),
+		"capacities": capacities,
 	})
 	if err != nil {
 		return err
@@ -46,14 +92,53 @@ func renderInterchanges(s *Server, w http.ResponseWriter, r *http.Request, confi
 	return nil
 }
 
+// channelCapacity is how many URNs are mapped to a channel, its capacity and what happens when it is full, for our
+// admin UI
+type channelCapacity struct {
+	Interchange string
+	Channel     string
+	UUID        string
+	Mapped      int
+	Capacity    int
+	WhenFull    *models.WhenFull
+}
+
+// channelCapacities returns the capacity of every channel in our saved config
+func channelCapacities(s *Server, r *http.Request) ([]*channelCapacity, error) {
+	interchanges, err := s.store.GetInterchangeConfig(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	capacities := make([]*channelCapacity, 0)
+	for _, interchange := range interchanges {
+		counts, err := s.mappedCounts(r.Context(), interchange)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, channel := range interchange.Channels {
+			capacities = append(capacities, &channelCapacity{
+				Interchange: interchange.Name,
+				Channel:     channel.Name,
+				UUID:        channel.UUID,
+				Mapped:      counts[channel.UUID],
+				Capacity:    channel.Capacity,
+				WhenFull:    channel.WhenFull,
+			})
+		}
+	}
+	return capacities, nil
+}
+
 func viewConfig(s *Server, w http.ResponseWriter, r *http.Request) error {
-	interchanges, err := models.GetInterchangeConfig(r.Context(), s.db)
+	interchanges, err := s.store.GetInterchangeConfig(r.Context())
 	if err != nil {
 		slog.Error("error loading interchange config", "error", err)
 		return err
 	}
 
-	config, err := json.MarshalIndent(interchanges, "", "    ")
+	config, err := json.MarshalIndent(models.RedactInterchanges(interchanges), "", "    ")
 	if err != nil {
 		return err
 	}
@@ -61,12 +146,13 @@ func viewConfig(s *Server, w http.ResponseWriter, r *http.Request) error {
 }
 
 func updateConfig(s *Server, w http.ResponseWriter, r *http.Request) error {
-	interchanges, err := models.GetInterchangeConfig(r.Context(), s.db)
+	interchanges, err := s.store.GetInterchangeConfig(r.Context())
 	if err != nil {
 		return err
 	}
+	previous := interchanges
 
-	config, err := json.MarshalIndent(interchanges, "", "    ")
+	config, err := json.MarshalIndent(models.RedactInterchanges(interchanges), "", "    ")
 	if err != nil {
 		return err
 	}
@@ -77,7 +163,6 @@ func updateConfig(s *Server, w http.ResponseWriter, r *http.Request) error {
 	}
 
 	config = []byte(r.Form.Get("config"))
-	slog.Info("received new config", "config", string(config))
 
 	// try to create our config
 	interchanges = make([]*models.Interchange, 0)
@@ -86,19 +171,34 @@ func updateConfig(s *Server, w http.ResponseWriter, r *http.Request) error {
 		return renderInterchanges(s, w, r, config, "", err)
 	}
 
-	err = models.UpdateInterchangeConfig(r.Context(), s.db, interchanges)
+	// log what we received without any secrets it contains
+	redacted, _ := json.Marshal(models.RedactInterchanges(interchanges))
+	slog.Info("received new config", "config", string(redacted))
+
+	// any secrets left redacted keep their stored values
+	err = models.RestoreRedactedSecrets(interchanges, previous)
 	if err != nil {
 		return renderInterchanges(s, w, r, config, "", err)
 	}
 
+	err = s.store.UpdateInterchangeConfig(r.Context(), interchanges)
+	if err != nil {
+		return renderInterchanges(s, w, r, config, "", err)
+	}
+
+	// senders may now resolve to different interchanges
+	s.countries.invalidate()
+
 	// reselect our current interchanges
-	interchanges, err = models.GetInterchangeConfig(r.Context(), s.db)
+	interchanges, err = s.store.GetInterchangeConfig(r.Context())
 	if err != nil {
 		slog.Error("error loading interchange config", "error", err)
 		return err
 	}
 
-	config, err = json.MarshalIndent(interchanges, "", "    ")
+	s.recordAudit(r, models.AuditConfigUpdate, "config", models.RedactInterchanges(previous), models.RedactInterchanges(interchanges))
+
+	config, err = json.MarshalIndent(models.RedactInterchanges(interchanges), "", "    ")
 	if err != nil {
 		return err
 	}
@@ -119,12 +219,181 @@ func loadTemplate(fs http.FileSystem, name string) (*template.Template, error) {
 	return template.New(name).Parse(string(text))
 }
 
+// mappingResult is a mapping as returned by our API, including the name of the channel
+type mappingResult struct {
+	*models.URNMapping
+	ChannelName string `json:"channel_name"`
+}
+
+// mappingsPage is a page of mappings as returned by our API, next is set if there are more mappings
+type mappingsPage struct {
+	Mappings []*models.URNMapping `json:"mappings"`
+	Next     string               `json:"next,omitempty"`
+}
+
+const (
+	defaultMappingsPageSize = 100
+	maxMappingsPageSize     = 1000
+)
+
+// handles a request to list the mappings for an interchange
+func handleListMappings(s *Server, w http.ResponseWriter, r *http.Request) error {
+	interchangeUUID := chi.URLParam(r, "interchangeUUID")
+
+	// look up our interchange
+	interchange, err := s.store.GetInterchange(r.Context(), interchangeUUID)
+	if err != nil {
+		return err
+	}
+
+	if interchange == nil {
+		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "interchange not found", fmt.Errorf("interchange not found"))
+	}
+
+	params := r.URL.Query()
+	query := &models.URNMappingQuery{
+		Prefix:      params.Get("prefix"),
+		ChannelUUID: params.Get("channel"),
+		After:       params.Get("after"),
+		Limit:       defaultMappingsPageSize,
+	}
+
+	if query.ChannelUUID != "" && interchange.GetChannel(query.ChannelUUID) == nil {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "channel not found", fmt.Errorf("channel with UUID: %s not found", query.ChannelUUID))
+	}
+
+	if params.Get("limit") != "" {
+		query.Limit, err = strconv.Atoi(params.Get("limit"))
+		if err != nil || query.Limit < 1 || query.Limit > maxMappingsPageSize {
+			return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid limit", fmt.Errorf("limit must be between 1 and %d", maxMappingsPageSize))
+		}
+	}
+
+	mappings, err := s.store.ListURNMappings(r.Context(), interchange, query)
+	if err != nil {
+		return err
+	}
+
+	page := &mappingsPage{Mappings: mappings}
+	if len(mappings) == query.Limit {
+		page.Next = mappings[len(mappings)-1].URN
+	}
+
+	return writeDataResponse(r.Context(), w, http.StatusOK, "mappings", page)
+}
+
+// handles a request for the number of mappings for each channel in an interchange
+func handleCountMappings(s *Server, w http.ResponseWriter, r *http.Request) error {
+	interchangeUUID := chi.URLParam(r, "interchangeUUID")
+
+	// look up our interchange
+	interchange, err := s.store.GetInterchange(r.Context(), interchangeUUID)
+	if err != nil {
+		return err
+	}
+
+	if interchange == nil {
+		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "interchange not found", fmt.Errorf("interchange not found"))
+	}
+
+	counts, err := s.store.CountURNMappings(r.Context(), interchange)
+	if err != nil {
+		return err
+	}
+
+	return writeDataResponse(r.Context(), w, http.StatusOK, "mapping counts", counts)
+}
+
+// hashReport is which of the URNs in a hash report would move to a different channel
+type hashReport struct {
+	URNs   int         `json:"urns"`
+	Pinned int         `json:"pinned"`
+	Moved  []*hashMove `json:"moved"`
+}
+
+type hashMove struct {
+	URN  string `json:"urn"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// the maximum number of URNs in a single hash report
+const maxHashReportURNs = 100000
+
+// handles a request for which of the passed in URNs would move channel if an interchange which distributes senders
+// by hashing had the passed in channels. URNs with a stored mapping, such as from a keyword, aren't hashed so never
+// move. Channel health isn't taken into account.
+func handleHashReport(s *Server, w http.ResponseWriter, r *http.Request) error {
+	interchangeUUID := chi.URLParam(r, "interchangeUUID")
+
+	// look up our interchange
+	interchange, err := s.store.GetInterchange(r.Context(), interchangeUUID)
+	if err != nil {
+		return err
+	}
+
+	if interchange == nil {
+		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "interchange not found", fmt.Errorf("interchange not found"))
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		return err
+	}
+
+	proposed := make([]models.Channel, 0)
+	err = json.Unmarshal([]byte(r.Form.Get("channels")), &proposed)
+	if err != nil || len(proposed) == 0 {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid channels", fmt.Errorf("channels must be a JSON list of at least one channel"))
+	}
+
+	urns := strings.FieldsFunc(r.Form.Get("urns"), func(c rune) bool { return c == '\n' || c == '\r' || c == ',' })
+	if len(urns) > maxHashReportURNs {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "too many URNs", fmt.Errorf("reports are limited to %d URNs", maxHashReportURNs))
+	}
+
+	current := allChannels(interchange)
+	next := make([]*models.Channel, len(proposed))
+	for c := range proposed {
+		proposed[c].UUID = strings.ToLower(proposed[c].UUID)
+		next[c] = &proposed[c]
+	}
+
+	trimmed := make([]string, 0, len(urns))
+	for _, urn := range urns {
+		if urn = strings.TrimSpace(urn); urn != "" {
+			trimmed = append(trimmed, urn)
+		}
+	}
+
+	// look up which URNs have a stored mapping all at once
+	mapped, err := s.store.GetMappedURNs(r.Context(), interchange, trimmed)
+	if err != nil {
+		return err
+	}
+
+	report := &hashReport{URNs: len(trimmed), Moved: make([]*hashMove, 0)}
+	for _, urn := range trimmed {
+		if mapped[urn] {
+			report.Pinned++
+			continue
+		}
+
+		from, to := pickHashed(current, urn), pickHashed(next, urn)
+		if from.UUID != to.UUID {
+			report.Moved = append(report.Moved, &hashMove{URN: urn, From: from.UUID, To: to.UUID})
+		}
+	}
+
+	return writeDataResponse(r.Context(), w, http.StatusOK, "hash report", report)
+}
+
 // handles a mapping request
 func handleMap(s *Server, w http.ResponseWriter, r *http.Request) error {
 	interchangeUUID := chi.URLParam(r, "interchangeUUID")
 
 	// look up our interchange
-	interchange, err := models.GetInterchange(r.Context(), s.db, interchangeUUID)
+	interchange, err := s.store.GetInterchange(r.Context(), interchangeUUID)
 	if err != nil {
 		return err
 	}
@@ -139,37 +408,221 @@ func handleMap(s *Server, w http.ResponseWriter, r *http.Request) error {
 		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "missing urn", fmt.Errorf("missing urn field"))
 	}
 
+	// if this is a lookup of the current association
+	if r.Method == http.MethodGet {
+		mapping, err := s.store.GetURNMapping(r.Context(), interchange, urn)
+		if err != nil {
+			return err
+		}
+
+		if mapping == nil {
+			return writeErrorResponse(r.Context(), w, http.StatusNotFound, "mapping not found", fmt.Errorf("no mapping for urn: %s", urn))
+		}
+
+		result := &mappingResult{URNMapping: mapping}
+		if channel := interchange.GetChannel(mapping.ChannelUUID); channel != nil {
+			result.ChannelName = channel.Name
+		}
+
+		return writeDataResponse(r.Context(), w, http.StatusOK, "mapping found", result)
+	}
+
 	// if this creating a new association
 	if r.Method == http.MethodPost {
-		var channel *models.Channel
 		channelUUID := r.Form.Get("channel")
 
 		// check that that UUID is in our interchange
-		for _, c := range interchange.Channels {
-			if c.UUID == channelUUID {
-				channel = &c
-				break
-			}
-		}
-
+		channel := interchange.GetChannel(channelUUID)
 		if channel == nil {
 			return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "channel not found", fmt.Errorf("channel with UUID: %s not found", channelUUID))
 		}
 
+		previous, err := s.store.GetURNMapping(r.Context(), interchange, urn)
+		if err != nil {
+			return err
+		}
+
 		// associate our URN
-		err := models.SetChannelForURN(r.Context(), s.db, interchange, channel, urn)
+		err = s.store.SetChannelForURN(r.Context(), interchange, channel, urn)
 		if err != nil {
 			return err
 		}
 
+		current, err := s.store.GetURNMapping(r.Context(), interchange, urn)
+		if err != nil {
+			return err
+		}
+
+		s.recordAudit(r, models.AuditMappingSet, mappingTarget(interchange, urn), auditValue(previous), current)
 		return writeDataResponse(r.Context(), w, http.StatusOK, "mapping created", nil)
 	} else if r.Method == http.MethodDelete {
-		err := models.ClearChannelForURN(r.Context(), s.db, interchange, urn)
+		previous, err := s.store.GetURNMapping(r.Context(), interchange, urn)
+		if err != nil {
+			return err
+		}
+
+		err = s.store.ClearChannelForURN(r.Context(), interchange, urn)
 		if err != nil {
 			return err
 		}
+
+		s.recordAudit(r, models.AuditMappingClear, mappingTarget(interchange, urn), auditValue(previous), nil)
 		return writeDataResponse(r.Context(), w, http.StatusOK, "mapping removed", nil)
 	}
 
-	return writeErrorResponse(r.Context(), w, http.StatusMethodNotAllowed, "invalid method", fmt.Errorf("must be POST or DELETE"))
+	return writeErrorResponse(r.Context(), w, http.StatusMethodNotAllowed, "invalid method", fmt.Errorf("must be GET, POST or DELETE"))
+}
+
+// handles a request to export the mappings for an interchange
+func handleExport(s *Server, w http.ResponseWriter, r *http.Request) error {
+	interchangeUUID := chi.URLParam(r, "interchangeUUID")
+
+	// look up our interchange
+	interchange, err := s.store.GetInterchange(r.Context(), interchangeUUID)
+	if err != nil {
+		return err
+	}
+
+	if interchange == nil {
+		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "interchange not found", fmt.Errorf("interchange not found"))
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+	if format != ExportFormatCSV && format != ExportFormatJSONL {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid format", fmt.Errorf("format must be csv or jsonl"))
+	}
+
+	// if we are filtering by channel, check that it is in our interchange
+	channelUUID := r.URL.Query().Get("channel")
+	if channelUUID != "" && interchange.GetChannel(channelUUID) == nil {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "channel not found", fmt.Errorf("channel with UUID: %s not found", channelUUID))
+	}
+
+	// exports can take longer than our server write timeout, so lift it for this request
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		slog.Warn("unable to clear write deadline for export", "error", err)
+	}
+
+	w.Header().Set("Content-Type", ExportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, interchange.UUID, format))
+	w.WriteHeader(http.StatusOK)
+
+	// at this point our headers are written so we can only log any errors
+	err = ExportMappings(r.Context(), s.store, w, format, interchange.UUID, channelUUID)
+	if err != nil {
+		slog.Error("error exporting mappings", "interchange_uuid", interchange.UUID, "error", err)
+	}
+
+	return nil
+}
+
+// handles a request for our runtime statistics
+func handleStats(s *Server, w http.ResponseWriter, r *http.Request) error {
+	return writeDataResponse(r.Context(), w, http.StatusOK, "stats", map[string]interface{}{
+		"urn_cache":           models.GetURNCacheStats(),
+		"inbound_rejected":    s.rejected.snapshot(),
+		"inbound_blocked":     s.blocked.snapshot(),
+		"inbound_limited":     s.limited.snapshot(),
+		"inbound_duplicates":  s.duplicates.snapshot(),
+		"forward_failovers":   s.failovers.snapshot(),
+		"forward_unavailable": s.unavailable.snapshot(),
+		"breakers":            s.breakers.snapshot(),
+		"health_changes":      s.healthChanges.snapshot(),
+		"channels_unhealthy":  s.health.unhealthy(),
+		"assign_overflows":    s.overflows.snapshot(),
+		"assign_refusals":     s.refusals.snapshot(),
+		"schedule_closed":     s.closed.snapshot(),
+		"receive_fallbacks":   s.fallbacks.snapshot(),
+	})
+}
+
+// handles a request for the current health of the channels we check
+func handleHealth(s *Server, w http.ResponseWriter, r *http.Request) error {
+	states := s.health.snapshot()
+	changes := s.healthChanges.snapshot()
+	for i := range states {
+		states[i].Changes = changes[states[i].UUID]
+	}
+	return writeDataResponse(r.Context(), w, http.StatusOK, "health", states)
+}
+
+// escapes label values in our metrics
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// handles a request for the health of our channels as metrics in the Prometheus text format, so that they can be
+// scraped and alerted on
+func handleMetrics(s *Server, w http.ResponseWriter, r *http.Request) error {
+	b := &strings.Builder{}
+
+	b.WriteString("# HELP clover_channel_healthy Whether a channel passed its last health check.\n")
+	b.WriteString("# TYPE clover_channel_healthy gauge\n")
+	for _, state := range s.health.snapshot() {
+		healthy := 0
+		if state.Healthy {
+			healthy = 1
+		}
+		fmt.Fprintf(b, "clover_channel_healthy{channel_uuid=\"%s\",name=\"%s\"} %d\n", state.UUID, metricLabelEscaper.Replace(state.Name), healthy)
+	}
+
+	changes := s.healthChanges.snapshot()
+	uuids := make([]string, 0, len(changes))
+	for uuid := range changes {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	b.WriteString("# HELP clover_channel_health_changes_total How many times the health of a channel has changed.\n")
+	b.WriteString("# TYPE clover_channel_health_changes_total counter\n")
+	for _, uuid := range uuids {
+		fmt.Fprintf(b, "clover_channel_health_changes_total{channel_uuid=\"%s\"} %d\n", uuid, changes[uuid])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// the default and maximum number of health check results returned at once
+const (
+	defaultHealthChecksPageSize = 100
+	maxHealthChecksPageSize     = 1000
+)
+
+// handles a request for the recent health check results of a channel
+func handleHealthHistory(s *Server, w http.ResponseWriter, r *http.Request) error {
+	channelUUID := strings.ToLower(chi.URLParam(r, "channelUUID"))
+
+	interchanges, err := s.store.GetInterchangeConfig(r.Context())
+	if err != nil {
+		return err
+	}
+
+	var channel *models.Channel
+	for _, interchange := range interchanges {
+		if channel = interchange.GetChannel(channelUUID); channel != nil {
+			break
+		}
+	}
+	if channel == nil {
+		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "channel not found", fmt.Errorf("channel with UUID: %s not found", channelUUID))
+	}
+
+	limit := defaultHealthChecksPageSize
+	if r.URL.Query().Get("limit") != "" {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 || limit > maxHealthChecksPageSize {
+			return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid limit", fmt.Errorf("limit must be between 1 and %d", maxHealthChecksPageSize))
+		}
+	}
+
+	checks, err := s.store.GetHealthChecks(r.Context(), channel.UUID, limit)
+	if err != nil {
+		return err
+	}
+	return writeDataResponse(r.Context(), w, http.StatusOK, "health_checks", checks)
 }
@@ -0,0 +1,145 @@
+package clover
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// the number of audit entries we show per page
+const auditPageSize = 50
+
+// recordAudit records a change made by the passed in request in our audit log, failures are logged but
+// don't fail the request as the change has already been made
+func (s *Server) recordAudit(r *http.Request, action string, target string, before interface{}, after interface{}) {
+	actor := ""
+	if p := principalFromContext(r.Context()); p != nil {
+		actor = p.name
+	}
+
+	entry, err := models.NewAuditEntry(actor, action, target, before, after, sourceIP(r), middleware.GetReqID(r.Context()))
+	if err == nil {
+		err = s.store.InsertAuditEntry(r.Context(), entry)
+	}
+
+	if err != nil {
+		slog.Error("error recording audit entry", "action", action, "target", target, "error", err)
+	}
+}
+
+// mappingTarget returns the audit target for the mapping of the passed in URN
+func mappingTarget(interchange *models.Interchange, urn string) string {
+	return fmt.Sprintf("interchanges/%s/%s", interchange.UUID, urn)
+}
+
+// auditValue returns the passed in mapping as an audit value, this makes sure a missing mapping is recorded as null
+func auditValue(mapping *models.URNMapping) interface{} {
+	if mapping == nil {
+		return nil
+	}
+	return mapping
+}
+
+// auditQueryFromRequest builds an audit query from the filters in the passed in request
+func auditQueryFromRequest(r *http.Request) (*models.AuditQuery, error) {
+	params := r.URL.Query()
+	query := &models.AuditQuery{
+		Actor:  params.Get("actor"),
+		Action: params.Get("action"),
+		Target: params.Get("target"),
+		Limit:  auditPageSize,
+	}
+
+	var err error
+	if params.Get("since") != "" {
+		query.Since, err = time.Parse(time.RFC3339, params.Get("since"))
+		if err != nil {
+			return nil, fmt.Errorf("since must be an RFC3339 timestamp")
+		}
+	}
+	if params.Get("until") != "" {
+		query.Until, err = time.Parse(time.RFC3339, params.Get("until"))
+		if err != nil {
+			return nil, fmt.Errorf("until must be an RFC3339 timestamp")
+		}
+	}
+	if params.Get("before") != "" {
+		query.BeforeID, err = strconv.ParseInt(params.Get("before"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("before must be an audit entry id")
+		}
+	}
+
+	return query, nil
+}
+
+// handles a request to view our audit log
+func viewAudit(s *Server, w http.ResponseWriter, r *http.Request) error {
+	query, err := auditQueryFromRequest(r)
+	if err != nil {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid filter", err)
+	}
+
+	entries, err := s.store.GetAuditEntries(r.Context(), query)
+	if err != nil {
+		return err
+	}
+
+	// link to the next page of older entries if there might be one
+	next := ""
+	if len(entries) == auditPageSize {
+		params := r.URL.Query()
+		params.Set("before", strconv.FormatInt(entries[len(entries)-1].ID, 10))
+		next = params.Encode()
+	}
+
+	tpl, err := loadTemplate(s.fs, "/admin/audit.html")
+	if err != nil {
+		return err
+	}
+
+	return tpl.Execute(w, map[string]interface{}{
+		"entries": entries,
+		"filters": r.URL.Query(),
+		"next":    next,
+	})
+}
+
+// handles a request to export our audit log
+func handleAuditExport(s *Server, w http.ResponseWriter, r *http.Request) error {
+	query, err := auditQueryFromRequest(r)
+	if err != nil {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid filter", err)
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+	if format != ExportFormatCSV && format != ExportFormatJSONL {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid format", fmt.Errorf("format must be csv or jsonl"))
+	}
+
+	// exports can take longer than our server write timeout, so lift it for this request
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		slog.Warn("unable to clear write deadline for export", "error", err)
+	}
+
+	w.Header().Set("Content-Type", ExportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	// at this point our headers are written so we can only log any errors
+	err = ExportAudit(r.Context(), s.store, w, format, query)
+	if err != nil {
+		slog.Error("error exporting audit log", "error", err)
+	}
+
+	return nil
+}
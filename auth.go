@@ -0,0 +1,144 @@
+package clover
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// the username of our built-in admin user, which authenticates with the password in our config
+const builtinAdmin = "admin"
+
+// principal is who an admin request is being made by and what they are allowed to do
+type principal struct {
+	name        string
+	permissions map[models.Permission]bool
+
+	// the interchanges this principal is limited to, nil if not limited
+	interchanges map[string]bool
+}
+
+func newPrincipal(name string, permissions []models.Permission) *principal {
+	p := &principal{name: name, permissions: make(map[models.Permission]bool, len(permissions))}
+	for _, perm := range permissions {
+		p.permissions[perm] = true
+	}
+	return p
+}
+
+// newTokenPrincipal creates a principal for the passed in API token
+func newTokenPrincipal(token *models.APIToken) *principal {
+	p := newPrincipal(fmt.Sprintf("token:%d", token.ID), token.GrantedPermissions())
+	if len(token.InterchangeUUIDs) > 0 {
+		p.interchanges = make(map[string]bool, len(token.InterchangeUUIDs))
+		for _, uuid := range token.InterchangeUUIDs {
+			p.interchanges[strings.ToLower(uuid)] = true
+		}
+	}
+	return p
+}
+
+// canAccess returns whether this principal can access the passed in interchange, or if the UUID is empty,
+// resources which aren't specific to an interchange
+func (p *principal) canAccess(interchangeUUID string) bool {
+	if p.interchanges == nil {
+		return true
+	}
+	return p.interchanges[strings.ToLower(interchangeUUID)]
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// principalFromContext returns the principal making the current request, if any
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey).(*principal)
+	return p
+}
+
+// authenticate checks the credentials of admin requests, if valid the principal they belong to is added to the
+// request context, otherwise a 401 is returned
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.principalForRequest(r)
+		if err != nil {
+			slog.Error("error authenticating request", "error", err)
+			writeErrorResponse(r.Context(), w, http.StatusInternalServerError, "server error", err)
+			return
+		}
+
+		if p == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Clover"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, p)))
+	})
+}
+
+func (s *Server) principalForRequest(r *http.Request) (*principal, error) {
+	// machine clients authenticate with API tokens
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token, err := s.store.AuthenticateAPIToken(r.Context(), strings.TrimPrefix(auth, "Bearer "))
+		if err != nil || token == nil {
+			return nil, err
+		}
+		return newTokenPrincipal(token), nil
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	// our built-in admin user, if it is enabled
+	if s.config.Password != "" && subtle.ConstantTimeCompare([]byte(username), []byte(builtinAdmin)) == 1 {
+		if subtle.ConstantTimeCompare([]byte(password), []byte(s.config.Password)) != 1 {
+			return nil, nil
+		}
+		return newPrincipal(builtinAdmin, models.RoleAdmin.Permissions()), nil
+	}
+
+	user, err := s.store.AuthenticateUser(r.Context(), username, password)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	return newPrincipal(user.Username, user.Role.Permissions()), nil
+}
+
+// requirePermission returns middleware which only allows requests from principals with the passed in permission
+// and, for principals limited to specific interchanges, only for those interchanges
+func (s *Server) requirePermission(perm models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := principalFromContext(r.Context())
+			interchangeUUID := chi.URLParam(r, "interchangeUUID")
+
+			var err error
+			if p == nil || !p.permissions[perm] {
+				err = fmt.Errorf("missing permission: %s", perm)
+			} else if !p.canAccess(interchangeUUID) {
+				err = fmt.Errorf("no access to interchange: %s", interchangeUUID)
+			}
+
+			if err != nil {
+				err = writeErrorResponse(r.Context(), w, http.StatusForbidden, "forbidden", err)
+				if err != nil {
+					slog.Error("error writing error response", "error", err)
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package clover
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// the states a channel's breaker can be in
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// how a request forwarded to a channel turned out, abandoned requests say nothing about the channel's health
+type forwardOutcome int
+
+const (
+	forwardSucceeded forwardOutcome = iota
+	forwardFailed
+	forwardAbandoned
+)
+
+// breakers tracks the consecutive failures of each channel we forward to on this instance. Once a channel has failed
+// as many times in a row as it allows, its breaker opens and we stop forwarding to it until it has cooled down, when a
+// single trial request is let through to decide whether it closes again or stays open for another cooldown.
+type breakers struct {
+	mutex    sync.Mutex
+	channels map[string]*breaker
+}
+
+type breaker struct {
+	name     string
+	failures int
+	openedOn time.Time
+	trial    bool
+}
+
+// breakerStatus is the state of a channel's breaker as reported in our stats and admin UI
+type breakerStatus struct {
+	UUID     string     `json:"uuid"`
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedOn *time.Time `json:"opened_on,omitempty"`
+}
+
+func newBreakers() *breakers {
+	return &breakers{channels: make(map[string]*breaker)}
+}
+
+// allow returns whether we can forward a request to the passed in channel
+func (b *breakers) allow(channel *models.Channel, now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	br := b.channels[channel.UUID]
+	if br == nil || br.failures < channel.BreakerFailures() {
+		return true
+	}
+
+	// still cooling down or already trying the channel again
+	if now.Before(br.openedOn.Add(channel.BreakerCooldown())) || br.trial {
+		return false
+	}
+
+	br.trial = true
+	return true
+}
+
+// record records the outcome of a request we forwarded to the passed in channel
+func (b *breakers) record(channel *models.Channel, outcome forwardOutcome, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	br := b.channels[channel.UUID]
+
+	switch outcome {
+	case forwardSucceeded:
+		delete(b.channels, channel.UUID)
+
+	case forwardFailed:
+		if br == nil {
+			br = &breaker{}
+			b.channels[channel.UUID] = br
+		}
+		br.name = channel.Name
+		br.failures++
+		br.trial = false
+		if br.failures >= channel.BreakerFailures() {
+			br.openedOn = now
+		}
+
+	case forwardAbandoned:
+		if br != nil {
+			br.trial = false
+		}
+	}
+}
+
+// snapshot returns the status of every channel which has failed since it last succeeded, ordered by channel UUID
+func (b *breakers) snapshot() []breakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	statuses := make([]breakerStatus, 0, len(b.channels))
+	for uuid, br := range b.channels {
+		status := breakerStatus{UUID: uuid, Name: br.name, State: breakerClosed, Failures: br.failures}
+		if !br.openedOn.IsZero() {
+			openedOn := br.openedOn
+			status.OpenedOn = &openedOn
+			status.State = breakerOpen
+			if br.trial {
+				status.State = breakerHalfOpen
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].UUID < statuses[j].UUID })
+	return statuses
+}
+
+// clients are the clients we forward requests with, one per connect timeout so that channels with the same timeout
+// share a pool of connections
+type clients struct {
+	mutex   sync.Mutex
+	clients map[time.Duration]*http.Client
+}
+
+func newClients() *clients {
+	return &clients{clients: make(map[time.Duration]*http.Client)}
+}
+
+// forChannel returns the client to forward requests to the passed in channel with
+func (c *clients) forChannel(channel *models.Channel) *http.Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	timeout := channel.ConnectTimeout()
+	client, found := c.clients[timeout]
+	if !found {
+		dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+		client = &http.Client{Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     30 * time.Second,
+		}}
+		c.clients[timeout] = client
+	}
+	return client
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	clover "github.com/nyaruka/rp-clover"
+	"github.com/nyaruka/rp-clover/migrations"
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// command is a command line task that can be run instead of starting the server, these read their configuration
+// from our TOML file and environment variables and take their own flags
+type command struct {
+	usage string
+	run   func(config *clover.Config, args []string) error
+}
+
+const (
+	exportUsage        = "export [-format csv|jsonl] [-channel uuid] <interchange-uuid>"
+	migrateStatusUsage = "migrate-status"
+	migrateDownUsage   = "migrate-down <version>  (reverts all migrations newer than version)"
+	userCreateUsage    = "user-create [-role viewer|operator|admin] <username>  (password is read from stdin)"
+	userDeleteUsage    = "user-delete <username>"
+)
+
+var commands = map[string]command{
+	"export":         {exportUsage, runExport},
+	"migrate-status": {migrateStatusUsage, runMigrateStatus},
+	"migrate-down":   {migrateDownUsage, runMigrateDown},
+	"user-create":    {userCreateUsage, runUserCreate},
+	"user-delete":    {userDeleteUsage, runUserDelete},
+}
+
+// commandUsage returns the usage for all our commands
+func commandUsage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	usage := "Commands:\n"
+	for _, name := range names {
+		usage += fmt.Sprintf("  %s\n", commands[name].usage)
+	}
+	return usage
+}
+
+func openStore(config *clover.Config) (models.Store, error) {
+	return models.OpenStore(context.Background(), config.DB)
+}
+
+// exports the mappings for an interchange to stdout, everything is checked before we write any output
+func runExport(config *clover.Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", clover.ExportFormatCSV, "the format to export in, one of csv, jsonl")
+	channel := flags.String("channel", "", "only export mappings for this channel")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: %s", exportUsage)
+	}
+	if *format != clover.ExportFormatCSV && *format != clover.ExportFormatJSONL {
+		return fmt.Errorf("format must be csv or jsonl")
+	}
+
+	store, err := openStore(config)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	interchange, err := store.GetInterchange(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if interchange == nil {
+		return fmt.Errorf("no interchange with UUID: %s", flags.Arg(0))
+	}
+	if *channel != "" && interchange.GetChannel(*channel) == nil {
+		return fmt.Errorf("channel with UUID: %s not found", *channel)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err = clover.ExportMappings(ctx, store, out, *format, interchange.UUID, *channel)
+	if err != nil {
+		return err
+	}
+
+	return out.Flush()
+}
+
+// creates an admin user, or updates the password and role of an existing one
+func runUserCreate(config *clover.Config, args []string) error {
+	flags := flag.NewFlagSet("user-create", flag.ExitOnError)
+	role := flags.String("role", string(models.RoleAdmin), "the role of the user, one of viewer, operator, admin")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: %s", userCreateUsage)
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("error reading password: %w", err)
+	}
+
+	store, err := openStore(config)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	err = store.SaveUser(context.Background(), flags.Arg(0), strings.TrimRight(password, "\r\n"), models.Role(*role))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "user %s saved with role %s\n", flags.Arg(0), *role)
+	return nil
+}
+
+// deletes an admin user
+func runUserDelete(config *clover.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", userDeleteUsage)
+	}
+
+	store, err := openStore(config)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	deleted, err := store.DeleteUser(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("no user with username: %s", args[0])
+	}
+
+	fmt.Fprintf(os.Stderr, "user %s deleted\n", args[0])
+	return nil
+}
+
+// prints which migrations have been applied and which are pending
+func runMigrateStatus(config *clover.Config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: %s", migrateStatusUsage)
+	}
+
+	db, err := models.OpenDB(context.Background(), config.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	statuses, err := migrations.GetStatus(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, status := range statuses {
+		state := "pending"
+		if status.AppliedOn != nil {
+			state = "applied " + status.AppliedOn.UTC().Format(time.RFC3339)
+		}
+		if status.Modified {
+			state += " (modified since applied)"
+		}
+		fmt.Fprintf(out, "%d\t%s\t%s\n", status.Version, status.Description, state)
+	}
+	return out.Flush()
+}
+
+// reverts all migrations newer than a version
+func runMigrateDown(config *clover.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", migrateDownUsage)
+	}
+
+	version, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid version: %s", args[0])
+	}
+
+	db, err := models.OpenDB(context.Background(), config.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = migrations.MigrateDown(context.Background(), db, version)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "migrated down to version %d\n", version)
+	return nil
+}
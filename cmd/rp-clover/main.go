@@ -15,6 +15,7 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/nyaruka/ezconf"
 	clover "github.com/nyaruka/rp-clover"
+	"github.com/nyaruka/rp-clover/models"
 	"github.com/rakyll/statik/fs"
 	slogmulti "github.com/samber/slog-multi"
 	slogsentry "github.com/samber/slog-sentry"
@@ -25,8 +26,18 @@ import (
 var version = "Dev"
 
 func main() {
+	// if we were asked to run a command, pull it and its arguments out before loading our config
+	var cmd *command
+	var cmdArgs []string
+	if len(os.Args) > 1 {
+		if c, found := commands[os.Args[1]]; found {
+			cmd, cmdArgs = &c, os.Args[2:]
+			os.Args = os.Args[:1]
+		}
+	}
+
 	config := clover.NewConfig()
-	loader := ezconf.NewLoader(&config, "clover", "Clover takes care of routing RapidPro messages based on membership.", []string{"clover.toml"})
+	loader := ezconf.NewLoader(&config, "clover", "Clover takes care of routing RapidPro messages based on membership.\n\n"+commandUsage(), []string{"clover.toml"})
 	loader.MustLoad()
 
 	var level slog.Level
@@ -36,8 +47,12 @@ func main() {
 		os.Exit(1)
 	}
 
-	// configure our logger
-	logHandler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	// configure our logger, commands may write their output to stdout so they log to stderr
+	logOutput := os.Stdout
+	if cmd != nil {
+		logOutput = os.Stderr
+	}
+	logHandler := slog.NewTextHandler(logOutput, &slog.HandlerOptions{Level: level})
 	slog.SetDefault(slog.New(logHandler))
 
 	logger := slog.With("comp", "main")
@@ -67,15 +82,27 @@ func main() {
 	}
 
 	// our settings shouldn't contain a timezone, nothing will work right with this not being a constant UTC
-	if strings.Contains(config.DB, "TimeZone") {
-		logger.Error("invalid db connection string, do not specify a timezone, archiver always uses UTC", "db", config.DB)
+	if models.IsPostgresDSN(config.DB) {
+		if strings.Contains(config.DB, "TimeZone") {
+			logger.Error("invalid db connection string, do not specify a timezone, archiver always uses UTC", "db", config.DB)
+		}
+
+		// force our DB connection to be in UTC
+		if strings.Contains(config.DB, "?") {
+			config.DB += "&TimeZone=UTC"
+		} else {
+			config.DB += "?TimeZone=UTC"
+		}
 	}
 
-	// force our DB connection to be in UTC
-	if strings.Contains(config.DB, "?") {
-		config.DB += "&TimeZone=UTC"
-	} else {
-		config.DB += "?TimeZone=UTC"
+	// run our command if we have one instead of starting our server
+	if cmd != nil {
+		err := cmd.run(config, cmdArgs)
+		if err != nil {
+			logger.Error("error running command", "error", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	var templateFS http.FileSystem
@@ -101,7 +128,7 @@ func main() {
 		logger.Error("error starting clover", "error", err)
 	}
 
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	logger.Info("stopping clover", "signal", <-ch)
 
@@ -2,13 +2,30 @@ package clover
 
 // Config is our top level configuration object
 type Config struct {
-	DB        string `help:"the connection string for our database"`
+	DB        string `help:"the connection string for our database, sqlite:<path> for an embedded sqlite database or memory: to keep everything in memory"`
 	LogLevel  string `help:"the log level, one of error, warn, info, debug"`
 	SentryDSN string `help:"the sentry configuration to log errors to, if any"`
 	Version   string `help:"the version being run"`
-	Password  string `help:"the password for the admin user"`
+	Password  string `help:"the password for the built-in admin user, leave empty to only allow users created in the database"`
 	Address   string `help:"the address clover will listen on"`
 	Port      int    `help:"the port clover will listen on"`
+
+	TrustedProxies string `help:"comma separated IPs or CIDRs of the proxies whose X-Forwarded-For headers we trust"`
+	TrustRealIP    bool   `help:"whether our trusted proxies set X-Real-IP to the client address, overwriting any sent by clients, otherwise it is ignored"`
+
+	TLSCert     string `help:"the path of a certificate to serve HTTPS with, reloaded when it changes"`
+	TLSKey      string `help:"the path of the private key for our TLS certificate, reloaded when it changes"`
+	TLSClientCA string `help:"the path of a CA bundle which clients must present a certificate signed by, enabling mutual TLS"`
+
+	URNCacheSize int    `help:"the maximum number of URN mappings to cache in memory, 0 to disable caching"`
+	RateLimiter  string `help:"where rate limit state is kept, store to share it with other instances using our database or memory to keep it in this instance"`
+
+	HealthCheckInterval int `help:"the number of seconds between health checks of the channels which configure them, 0 to disable health checks"`
+
+	FallbackInterchange string `help:"the UUID of the interchange our shared receive endpoint sends requests to when it can't tell which interchange their sender belongs to, leave empty to reject them"`
+
+	DrainDelay   int `help:"the number of seconds to keep serving requests after reporting not ready when stopping"`
+	DrainTimeout int `help:"the maximum number of seconds to wait for in-flight requests and background work when stopping"`
 }
 
 // NewConfig returns a new default configuration object
@@ -20,6 +37,16 @@ func NewConfig() *Config {
 		Port:     8081,
 		Version:  "Dev",
 		Password: "sesame123",
+
+		TrustedProxies: "127.0.0.1,::1",
+
+		URNCacheSize: 100000,
+		RateLimiter:  "store",
+
+		HealthCheckInterval: 30,
+
+		DrainDelay:   0,
+		DrainTimeout: 30,
 	}
 
 	return &config
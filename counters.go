@@ -0,0 +1,34 @@
+package clover
+
+import (
+	"sync"
+)
+
+// counters is a set of named counts which are safe to increment concurrently, these are reported in our stats
+type counters struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+}
+
+func newCounters() *counters {
+	return &counters{counts: make(map[string]int64)}
+}
+
+// inc increments the count with the passed in key
+func (c *counters) inc(key string) {
+	c.mutex.Lock()
+	c.counts[key]++
+	c.mutex.Unlock()
+}
+
+// snapshot returns a copy of our current counts
+func (c *counters) snapshot() map[string]int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	counts := make(map[string]int64, len(c.counts))
+	for key, count := range c.counts {
+		counts[key] = count
+	}
+	return counts
+}
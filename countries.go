@@ -0,0 +1,248 @@
+package clover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// the reasons our shared receive endpoint falls back to our configured interchange
+const (
+	fallbackUnknownCountry       = "unknown_country"
+	fallbackAmbiguousCountry     = "ambiguous_country"
+	fallbackNoInterchange        = "no_interchange"
+	fallbackAmbiguousInterchange = "ambiguous_interchange"
+)
+
+// callingCodes are the countries which use each international calling code. Where a code is shared, each country
+// other than the one which holds most of it is listed by the number ranges it uses, and a number belongs to the
+// country of the longest prefix it starts with. The few codes whose countries share number ranges list them all.
+var callingCodes = map[string][]string{
+	"1": {"US"}, "7": {"RU"},
+
+	"20": {"EG"}, "27": {"ZA"}, "30": {"GR"}, "31": {"NL"}, "32": {"BE"}, "33": {"FR"}, "34": {"ES"}, "36": {"HU"},
+	"39": {"IT"}, "40": {"RO"}, "41": {"CH"}, "43": {"AT"}, "44": {"GB"}, "45": {"DK"}, "46": {"SE"}, "47": {"NO"},
+	"48": {"PL"}, "49": {"DE"}, "51": {"PE"}, "52": {"MX"}, "53": {"CU"}, "54": {"AR"}, "55": {"BR"}, "56": {"CL"},
+	"57": {"CO"}, "58": {"VE"}, "60": {"MY"}, "61": {"AU"}, "62": {"ID"}, "63": {"PH"}, "64": {"NZ", "PN"},
+	"65": {"SG"}, "66": {"TH"}, "81": {"JP"}, "82": {"KR"}, "84": {"VN"}, "86": {"CN"}, "90": {"TR"}, "91": {"IN"},
+	"92": {"PK"}, "93": {"AF"}, "94": {"LK"}, "95": {"MM"}, "98": {"IR"},
+
+	"211": {"SS"}, "212": {"MA", "EH"}, "213": {"DZ"}, "216": {"TN"}, "218": {"LY"}, "220": {"GM"}, "221": {"SN"},
+	"222": {"MR"}, "223": {"ML"}, "224": {"GN"}, "225": {"CI"}, "226": {"BF"}, "227": {"NE"}, "228": {"TG"},
+	"229": {"BJ"}, "230": {"MU"}, "231": {"LR"}, "232": {"SL"}, "233": {"GH"}, "234": {"NG"}, "235": {"TD"},
+	"236": {"CF"}, "237": {"CM"}, "238": {"CV"}, "239": {"ST"}, "240": {"GQ"}, "241": {"GA"}, "242": {"CG"},
+	"243": {"CD"}, "244": {"AO"}, "245": {"GW"}, "246": {"IO"}, "247": {"SH"}, "248": {"SC"}, "249": {"SD"},
+	"250": {"RW"}, "251": {"ET"}, "252": {"SO"}, "253": {"DJ"}, "254": {"KE"}, "255": {"TZ"}, "256": {"UG"},
+	"257": {"BI"}, "258": {"MZ"}, "260": {"ZM"}, "261": {"MG"}, "262": {"RE"}, "263": {"ZW"}, "264": {"NA"},
+	"265": {"MW"}, "266": {"LS"}, "267": {"BW"}, "268": {"SZ"}, "269": {"KM"}, "290": {"SH"}, "291": {"ER"},
+	"297": {"AW"}, "298": {"FO"}, "299": {"GL"},
+
+	"350": {"GI"}, "351": {"PT"}, "352": {"LU"}, "353": {"IE"}, "354": {"IS"}, "355": {"AL"}, "356": {"MT"},
+	"357": {"CY"}, "358": {"FI"}, "359": {"BG"}, "370": {"LT"}, "371": {"LV"}, "372": {"EE"}, "373": {"MD"},
+	"374": {"AM"}, "375": {"BY"}, "376": {"AD"}, "377": {"MC"}, "378": {"SM"}, "379": {"VA"}, "380": {"UA"},
+	"381": {"RS"}, "382": {"ME"}, "383": {"XK"}, "385": {"HR"}, "386": {"SI"}, "387": {"BA"}, "389": {"MK"},
+	"420": {"CZ"}, "421": {"SK"}, "423": {"LI"},
+
+	"500": {"FK"}, "501": {"BZ"}, "502": {"GT"}, "503": {"SV"}, "504": {"HN"}, "505": {"NI"}, "506": {"CR"},
+	"507": {"PA"}, "508": {"PM"}, "509": {"HT"}, "590": {"GP", "BL", "MF"}, "591": {"BO"}, "592": {"GY"},
+	"593": {"EC"}, "594": {"GF"}, "595": {"PY"}, "596": {"MQ"}, "597": {"SR"}, "598": {"UY"}, "599": {"CW"},
+
+	"670": {"TL"}, "672": {"NF"}, "673": {"BN"}, "674": {"NR"}, "675": {"PG"}, "676": {"TO"}, "677": {"SB"},
+	"678": {"VU"}, "679": {"FJ"}, "680": {"PW"}, "681": {"WF"}, "682": {"CK"}, "683": {"NU"}, "685": {"WS"},
+	"686": {"KI"}, "687": {"NC"}, "688": {"TV"}, "689": {"PF"}, "690": {"TK"}, "691": {"FM"}, "692": {"MH"},
+
+	"850": {"KP"}, "852": {"HK"}, "853": {"MO"}, "855": {"KH"}, "856": {"LA"}, "880": {"BD"}, "886": {"TW"},
+
+	"960": {"MV"}, "961": {"LB"}, "962": {"JO"}, "963": {"SY"}, "964": {"IQ"}, "965": {"KW"}, "966": {"SA"},
+	"967": {"YE"}, "968": {"OM"}, "970": {"PS"}, "971": {"AE"}, "972": {"IL"}, "973": {"BH"}, "974": {"QA"},
+	"975": {"BT"}, "976": {"MN"}, "977": {"NP"}, "992": {"TJ"}, "993": {"TM"}, "994": {"AZ"}, "995": {"GE"},
+	"996": {"KG"}, "998": {"UZ"},
+
+	// the NANP area codes outside of the US
+	"1204": {"CA"}, "1226": {"CA"}, "1236": {"CA"}, "1249": {"CA"}, "1250": {"CA"}, "1257": {"CA"}, "1263": {"CA"},
+	"1289": {"CA"}, "1306": {"CA"}, "1343": {"CA"}, "1354": {"CA"}, "1365": {"CA"}, "1367": {"CA"}, "1368": {"CA"},
+	"1382": {"CA"}, "1387": {"CA"}, "1403": {"CA"}, "1416": {"CA"}, "1418": {"CA"}, "1428": {"CA"}, "1431": {"CA"},
+	"1437": {"CA"}, "1438": {"CA"}, "1450": {"CA"}, "1460": {"CA"}, "1468": {"CA"}, "1474": {"CA"}, "1506": {"CA"},
+	"1514": {"CA"}, "1519": {"CA"}, "1548": {"CA"}, "1579": {"CA"}, "1581": {"CA"}, "1584": {"CA"}, "1587": {"CA"},
+	"1600": {"CA"}, "1604": {"CA"}, "1613": {"CA"}, "1639": {"CA"}, "1647": {"CA"}, "1672": {"CA"}, "1683": {"CA"},
+	"1705": {"CA"}, "1709": {"CA"}, "1742": {"CA"}, "1753": {"CA"}, "1778": {"CA"}, "1780": {"CA"}, "1782": {"CA"},
+	"1807": {"CA"}, "1819": {"CA"}, "1825": {"CA"}, "1867": {"CA"}, "1873": {"CA"}, "1879": {"CA"}, "1902": {"CA"},
+	"1905": {"CA"}, "1942": {"CA"},
+	"1242": {"BS"}, "1246": {"BB"}, "1264": {"AI"}, "1268": {"AG"}, "1284": {"VG"}, "1340": {"VI"}, "1345": {"KY"},
+	"1441": {"BM"}, "1473": {"GD"}, "1649": {"TC"}, "1658": {"JM"}, "1664": {"MS"}, "1670": {"MP"}, "1671": {"GU"},
+	"1684": {"AS"}, "1721": {"SX"}, "1758": {"LC"}, "1767": {"DM"}, "1784": {"VC"}, "1787": {"PR"}, "1809": {"DO"},
+	"1829": {"DO"}, "1849": {"DO"}, "1868": {"TT"}, "1869": {"KN"}, "1876": {"JM"}, "1939": {"PR"},
+
+	// Kazakhstan within Russia's code, the crown dependencies within the UK's, and the territories within the codes of
+	// Italy, Norway, Australia, Finland, Reunion and Curacao
+	"76": {"KZ"}, "77": {"KZ"},
+	"441624": {"IM"}, "447524": {"IM"}, "447624": {"IM"}, "447924": {"IM"},
+	"441481": {"GG"}, "447781": {"GG"}, "447839": {"GG"}, "447911": {"GG"},
+	"441534": {"JE"}, "447509": {"JE"}, "447700": {"JE"}, "447797": {"JE"}, "447829": {"JE"}, "447937": {"JE"},
+	"3906698": {"VA"}, "4779": {"SJ"}, "6189162": {"CC"}, "6189164": {"CX"}, "35818": {"AX"},
+	"262269": {"YT"}, "262639": {"YT"}, "5997": {"BQ"},
+}
+
+// the longest prefix in our calling codes
+const maxCallingPrefix = 7
+
+// senderCountries returns the countries the passed in sender could be in, which is more than one only for the few
+// calling codes whose countries share number ranges
+func senderCountries(sender string) []string {
+	number := strings.TrimLeft(sender, "+")
+	for length := min(len(number), maxCallingPrefix); length > 0; length-- {
+		if countries, found := callingCodes[number[:length]]; found {
+			return countries
+		}
+	}
+	return nil
+}
+
+// handles a request to our shared receive endpoint, which dispatches it to the interchange for the country of its
+// sender, or to our fallback interchange if we can't tell what that is
+func handleSharedReceive(s *Server, w http.ResponseWriter, r *http.Request) error {
+	scheme := chi.URLParam(r, "scheme")
+	if scheme == "" {
+		scheme = "tel"
+	}
+
+	// parse our sender from a copy of our request so that its body is still there to be verified and forwarded
+	body, err := readBody(w, r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return writeErrorResponse(r.Context(), w, http.StatusRequestEntityTooLarge, "request body too large", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	peek := r.Clone(r.Context())
+	peek.Body = io.NopCloser(bytes.NewReader(body))
+	err = peek.ParseForm()
+	if err != nil {
+		return err
+	}
+
+	sender := peek.Form.Get("sender")
+	if sender == "" {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "missing sender field", fmt.Errorf("missing sender field"))
+	}
+
+	interchange, fallback, err := s.resolveInterchange(r.Context(), scheme, sender)
+	if err != nil {
+		return err
+	}
+
+	if fallback != "" {
+		s.fallbacks.inc(fallback)
+
+		if s.config.FallbackInterchange != "" {
+			interchange, err = s.store.GetInterchange(r.Context(), s.config.FallbackInterchange)
+			if err != nil {
+				return err
+			}
+		}
+		slog.Info("falling back for shared receive", "scheme", scheme, "sender", sender, "reason", fallback, "fallback_uuid", s.config.FallbackInterchange)
+	}
+
+	if interchange == nil {
+		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "interchange not found", fmt.Errorf("interchange not found"))
+	}
+
+	return handleReceive(s, w, r, interchange)
+}
+
+// resolveInterchange finds the interchange with the passed in scheme for the country of the passed in sender. If the
+// sender could be in more than one country, we use the only one of those which has interchanges. If we can't find
+// exactly one interchange we return why so that we can fall back to our configured interchange.
+func (s *Server) resolveInterchange(ctx context.Context, scheme string, sender string) (*models.Interchange, string, error) {
+	countries := senderCountries(sender)
+	if len(countries) == 0 {
+		return nil, fallbackUnknownCountry, nil
+	}
+
+	var uuids []string
+	for _, country := range countries {
+		found, err := s.countries.lookup(ctx, s.store, scheme, country)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(found) > 0 {
+			if len(uuids) > 0 {
+				return nil, fallbackAmbiguousCountry, nil
+			}
+			uuids = found
+		}
+	}
+
+	if len(uuids) == 0 {
+		return nil, fallbackNoInterchange, nil
+	}
+	if len(uuids) > 1 {
+		return nil, fallbackAmbiguousInterchange, nil
+	}
+
+	interchange, err := s.store.GetInterchange(ctx, uuids[0])
+	if err != nil {
+		return nil, "", err
+	}
+	if interchange == nil {
+		return nil, fallbackNoInterchange, nil
+	}
+	return interchange, "", nil
+}
+
+// countryIndex is the UUIDs of our interchanges by scheme and country, so that we don't load our whole config for
+// every request to our shared receive endpoint. It is reloaded when we save our config, when we hear that another
+// instance changed an interchange, and like our cached interchanges, once it is a minute old.
+type countryIndex struct {
+	mutex    sync.Mutex
+	uuids    map[string][]string
+	loadedOn time.Time
+	changes  uint64
+}
+
+func newCountryIndex() *countryIndex {
+	return &countryIndex{}
+}
+
+// invalidate makes us reload on our next lookup
+func (x *countryIndex) invalidate() {
+	x.mutex.Lock()
+	x.uuids = nil
+	x.mutex.Unlock()
+}
+
+// lookup returns the UUIDs of the interchanges with the passed in scheme and country
+func (x *countryIndex) lookup(ctx context.Context, store models.Store, scheme string, country string) ([]string, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	changes := models.InterchangeChanges()
+	if x.uuids == nil || x.changes != changes || time.Since(x.loadedOn) >= time.Minute {
+		interchanges, err := store.GetInterchangeConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		x.uuids = make(map[string][]string, len(interchanges))
+		for _, interchange := range interchanges {
+			key := interchange.Scheme + ":" + strings.ToUpper(interchange.Country)
+			x.uuids[key] = append(x.uuids[key], interchange.UUID)
+		}
+		x.loadedOn = time.Now()
+		x.changes = changes
+	}
+
+	return x.uuids[scheme+":"+strings.ToUpper(country)], nil
+}
@@ -0,0 +1,50 @@
+package clover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// how often we remove expired dedup keys
+var dedupPruneInterval = time.Minute
+
+// messageKey returns the key which identifies the message in the passed in request for duplicate suppression. This
+// is the value of our external message ID field if we have one, otherwise a hash of the sender and text. Returns an
+// empty string if the message can't be identified.
+func messageKey(dedup *models.Dedup, r *http.Request, urn string) string {
+	if dedup.Field != "" {
+		id := r.Form.Get(dedup.Field)
+		if id == "" {
+			return ""
+		}
+		return "id:" + id
+	}
+
+	hash := sha256.Sum256([]byte(urn + "\n" + r.Form.Get("message")))
+	return "hash:" + hex.EncodeToString(hash[:])
+}
+
+// recordingWriter writes through to a response writer, keeping a copy of the status and body written
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
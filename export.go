@@ -0,0 +1,113 @@
+package clover
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// the formats we support exporting mappings in
+const (
+	ExportFormatCSV   = "csv"
+	ExportFormatJSONL = "jsonl"
+)
+
+// ExportContentType returns the content type for the passed in export format
+func ExportContentType(format string) string {
+	if format == ExportFormatJSONL {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// ExportMappings writes all the mappings for the passed in interchange to w in the passed in format, optionally
+// limited to a single channel. Mappings are streamed from our store as they are written.
+func ExportMappings(ctx context.Context, store models.Store, w io.Writer, format string, interchangeUUID string, channelUUID string) error {
+	switch format {
+	case ExportFormatCSV:
+		writer := csv.NewWriter(w)
+		err := writer.Write([]string{"urn", "interchange_uuid", "channel_uuid", "created_on", "modified_on"})
+		if err != nil {
+			return err
+		}
+
+		err = store.ExportURNMappings(ctx, interchangeUUID, channelUUID, func(m *models.URNMapping) error {
+			return writer.Write([]string{
+				m.URN,
+				m.InterchangeUUID,
+				m.ChannelUUID,
+				m.CreatedOn.UTC().Format(time.RFC3339),
+				m.ModifiedOn.UTC().Format(time.RFC3339),
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	case ExportFormatJSONL:
+		encoder := json.NewEncoder(w)
+		return store.ExportURNMappings(ctx, interchangeUUID, channelUUID, func(m *models.URNMapping) error {
+			return encoder.Encode(m)
+		})
+	}
+
+	return fmt.Errorf("unknown export format: %s", format)
+}
+
+// ExportAudit writes all the audit entries matching the passed in query to w in the passed in format, newest first
+func ExportAudit(ctx context.Context, store models.Store, w io.Writer, format string, query *models.AuditQuery) error {
+	switch format {
+	case ExportFormatCSV:
+		writer := csv.NewWriter(w)
+		err := writer.Write([]string{"id", "created_on", "actor", "action", "target", "before", "after", "source_ip", "request_id"})
+		if err != nil {
+			return err
+		}
+
+		err = store.ExportAuditEntries(ctx, query, func(e *models.AuditEntry) error {
+			return writer.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.CreatedOn.UTC().Format(time.RFC3339),
+				e.Actor,
+				e.Action,
+				e.Target,
+				jsonString(e.Before),
+				jsonString(e.After),
+				e.SourceIP,
+				e.RequestID,
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	case ExportFormatJSONL:
+		encoder := json.NewEncoder(w)
+		return store.ExportAuditEntries(ctx, query, func(e *models.AuditEntry) error {
+			return encoder.Encode(e)
+		})
+	}
+
+	return fmt.Errorf("unknown export format: %s", format)
+}
+
+// jsonString returns the passed in JSON as a string, or an empty string if it is nil
+func jsonString(j *types.JSONText) string {
+	if j == nil {
+		return ""
+	}
+	return j.String()
+}
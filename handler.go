@@ -3,11 +3,17 @@ package clover
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,7 +26,7 @@ func handleInterchange(s *Server, w http.ResponseWriter, r *http.Request) error
 	interchangeUUID := chi.URLParam(r, "interchangeUUID")
 
 	// look up our interchange
-	interchange, err := models.GetInterchange(r.Context(), s.db, interchangeUUID)
+	interchange, err := s.store.GetInterchange(r.Context(), interchangeUUID)
 	if err != nil {
 		return err
 	}
@@ -29,8 +35,29 @@ func handleInterchange(s *Server, w http.ResponseWriter, r *http.Request) error
 		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "interchange not found", fmt.Errorf("interchange not found"))
 	}
 
+	return handleReceive(s, w, r, interchange)
+}
+
+// handles a request received for the passed in interchange
+func handleReceive(s *Server, w http.ResponseWriter, r *http.Request, interchange *models.Interchange) error {
+	// check our sender is allowed to send to this interchange
+	if !interchange.AllowsIP(sourceIP(r)) {
+		s.blocked.inc(interchange.UUID)
+		slog.Warn("rejected request from address not allowed", "interchange_uuid", interchange.UUID, "remote_addr", r.RemoteAddr)
+		return writeErrorResponse(r.Context(), w, http.StatusForbidden, "address not allowed", fmt.Errorf("address not allowed"))
+	}
+
+	// if our interchange requires verification, check that before we do anything else with this request
+	if interchange.Auth != nil {
+		if reason := verifyInbound(w, r, interchange.Auth, time.Now()); reason != "" {
+			s.rejected.inc(interchange.UUID)
+			slog.Warn("rejected unverified request", "interchange_uuid", interchange.UUID, "reason", reason, "remote_addr", r.RemoteAddr)
+			return writeErrorResponse(r.Context(), w, http.StatusUnauthorized, "request verification failed", errors.New(reason))
+		}
+	}
+
 	// get our URN from our incoming message
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		return err
 	}
@@ -41,6 +68,53 @@ func handleInterchange(s *Server, w http.ResponseWriter, r *http.Request) error
 	}
 	urn := interchange.Scheme + ":+" + strings.TrimLeft(sender, "+")
 
+	// check we are within our rate limits before we do any work for this request
+	if interchange.RateLimit != nil && s.overRateLimit(r.Context(), interchange, urn) {
+		s.limited.inc(interchange.UUID)
+		slog.Warn("request over rate limit", "interchange_uuid", interchange.UUID, "urn", urn, "action", interchange.RateLimit.Action)
+
+		if interchange.RateLimit.Action == models.RateLimitDrop {
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+		return writeErrorResponse(r.Context(), w, http.StatusTooManyRequests, "rate limit exceeded", fmt.Errorf("rate limit exceeded"))
+	}
+
+	// if we suppress duplicates, claim this message so that retries of it aren't forwarded again
+	dedupKey := ""
+	if interchange.Dedup != nil {
+		key := messageKey(interchange.Dedup, r, urn)
+		if key != "" {
+			window := time.Duration(interchange.Dedup.Window) * time.Second
+			claimed, previous, err := s.store.ClaimDedupKey(r.Context(), interchange.UUID, key, window)
+			if err != nil {
+				return err
+			}
+
+			if !claimed {
+				s.duplicates.inc(interchange.UUID)
+				slog.Info("suppressed duplicate request", "interchange_uuid", interchange.UUID, "urn", urn, "key", key)
+
+				if previous.StatusCode == 0 {
+					return writeErrorResponse(r.Context(), w, http.StatusConflict, "duplicate message still being forwarded", fmt.Errorf("duplicate message still being forwarded"))
+				}
+				w.WriteHeader(previous.StatusCode)
+				_, err = w.Write(previous.Body)
+				return err
+			}
+			dedupKey = key
+
+			// if we don't end up with a downstream response, release our claim so that a retry can be forwarded
+			defer func() {
+				if dedupKey != "" {
+					if err := s.store.ReleaseDedupKey(context.WithoutCancel(r.Context()), interchange.UUID, dedupKey); err != nil {
+						slog.Error("error releasing dedup key", "error", err, "interchange_uuid", interchange.UUID)
+					}
+				}
+			}()
+		}
+	}
+
 	// the channel we will route to
 	var routedChannel *models.Channel
 	var routingReason string
@@ -50,6 +124,7 @@ func handleInterchange(s *Server, w http.ResponseWriter, r *http.Request) error
 
 	// see if our text is any of our keywords, if so, assign this URN to that channel
 	message = strings.ToLower(strings.TrimSpace(message))
+	joined := false
 	for _, channel := range interchange.Channels {
 		for _, keyword := range channel.Keywords {
 			if message == keyword {
@@ -59,19 +134,24 @@ func handleInterchange(s *Server, w http.ResponseWriter, r *http.Request) error
 			}
 		}
 
-		// we found a matching channel, associate this URN
+		// we found a matching channel, associate this URN unless it is full
 		if routedChannel != nil {
-			err := models.SetChannelForURN(r.Context(), s.db, interchange, routedChannel, urn)
+			assigned, err := s.assignChannel(r.Context(), interchange, routedChannel, urn)
 			if err != nil {
-				return err
+				return s.writeAssignError(r.Context(), w, interchange, urn, err)
+			}
+			if assigned.UUID != routedChannel.UUID {
+				routingReason = fmt.Sprintf("%s, overflowed from %s", routingReason, routedChannel.UUID)
+				routedChannel = assigned
 			}
+			joined = true
 			break
 		}
 	}
 
 	// if not, look up current mapping for this URN
 	if routedChannel == nil {
-		routedChannel, err = models.GetChannelForURN(r.Context(), s.db, interchange, urn)
+		routedChannel, err = s.store.GetChannelForURN(r.Context(), interchange, urn)
 		if err != nil {
 			return err
 		}
@@ -81,10 +161,50 @@ func handleInterchange(s *Server, w http.ResponseWriter, r *http.Request) error
 		}
 	}
 
-	// didn't find any explicit routes, use our default chanel
+	// if not, see if the sender is in the number range of one of our prefix rules
 	if routedChannel == nil {
-		routedChannel = &interchange.Channels[0]
-		routingReason = "default channel"
+		if rule := interchange.MatchPrefixRule(urn); rule != nil {
+			routedChannel = interchange.GetChannel(rule.Channel)
+			routingReason = fmt.Sprintf("prefix '%s'", rule.Prefix)
+		}
+	}
+
+	// outside of the hours of one of our schedules, messages go to its channel instead. Keyword joins have already
+	// mapped the sender so go to the channel they joined, and some schedules only apply to senders we have no route for.
+	if schedule := interchange.ClosedSchedule(time.Now(), routedChannel == nil); schedule != nil && !joined {
+		scheduled := interchange.GetChannel(schedule.Channel)
+		if routedChannel == nil || routedChannel.UUID != scheduled.UUID {
+			s.closed.inc(interchange.UUID)
+			if routedChannel == nil {
+				routingReason = fmt.Sprintf("schedule '%s' closed", schedule.Name)
+			} else {
+				routingReason = fmt.Sprintf("%s, schedule '%s' closed", routingReason, schedule.Name)
+			}
+			routedChannel = scheduled
+		}
+	}
+
+	// didn't find any explicit routes, distribute this sender according to our interchange's strategy
+	if routedChannel == nil {
+		routedChannel, routingReason, err = s.distributeSender(r.Context(), interchange, urn)
+		if err != nil {
+			return s.writeAssignError(r.Context(), w, interchange, urn, err)
+		}
+	}
+
+	// if our channel's breaker is open, fail over to its failover channel if that can take requests, otherwise fail fast
+	now := time.Now()
+	if !s.breakers.allow(routedChannel, now) {
+		failover := interchange.GetChannel(routedChannel.FailoverUUID())
+		if failover == nil || !s.breakers.allow(failover, now) {
+			s.unavailable.inc(routedChannel.UUID)
+			slog.Warn("channel unavailable", "interchange_uuid", interchange.UUID, "channel_uuid", routedChannel.UUID, "urn", urn)
+			return writeErrorResponse(r.Context(), w, http.StatusServiceUnavailable, "channel unavailable", fmt.Errorf("channel unavailable"))
+		}
+
+		s.failovers.inc(routedChannel.UUID)
+		routingReason = fmt.Sprintf("%s, failed over from %s", routingReason, routedChannel.UUID)
+		routedChannel = failover
 	}
 
 	slog.Info("forwarding request",
@@ -96,10 +216,61 @@ func handleInterchange(s *Server, w http.ResponseWriter, r *http.Request) error
 		"routing_reason", routingReason,
 	)
 
-	return forwardRequest(r.Context(), w, r, interchange, routedChannel)
+	// track our forward so that we can wait for it when draining
+	defer s.work.begin(workForward)()
+
+	if dedupKey == "" {
+		return s.forwardRequest(r.Context(), w, r, routedChannel)
+	}
+
+	// remember the downstream response for retries, even if our client has gone away before we could write it. Server
+	// errors are likely transient, so we release our claim on those and let a retry be forwarded again.
+	recorder := &recordingWriter{ResponseWriter: w}
+	err = s.forwardRequest(r.Context(), recorder, r, routedChannel)
+	if recorder.status != 0 && recorder.status < http.StatusInternalServerError {
+		response := &models.DedupResponse{StatusCode: recorder.status, Body: recorder.body.Bytes()}
+		if err := s.store.CompleteDedupKey(context.WithoutCancel(r.Context()), interchange.UUID, dedupKey, response); err != nil {
+			slog.Error("error recording dedup response", "error", err, "interchange_uuid", interchange.UUID)
+		}
+		dedupKey = ""
+	}
+	return err
 }
 
-func forwardRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, interchange *models.Interchange, channel *models.Channel) error {
+// writeAssignError writes the response for a sender we couldn't assign a channel, which is the response configured
+// by the channel if it was full
+func (s *Server) writeAssignError(ctx context.Context, w http.ResponseWriter, interchange *models.Interchange, urn string, err error) error {
+	var full *channelFullError
+	if !errors.As(err, &full) {
+		return err
+	}
+
+	s.refusals.inc(full.channel.UUID)
+	slog.Warn("refused sender as channel is full", "interchange_uuid", interchange.UUID, "channel_uuid", full.channel.UUID, "urn", urn)
+
+	whenFull := full.channel.WhenFull
+	if whenFull == nil {
+		whenFull = &models.WhenFull{}
+	}
+	status := whenFull.Status
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	if whenFull.Body == "" {
+		return writeErrorResponse(ctx, w, status, "channel full", err)
+	}
+
+	w.WriteHeader(status)
+	_, err = w.Write([]byte(whenFull.Body))
+	return err
+}
+
+// forwardRequest forwards the passed in request to a channel, giving up if it can't connect or respond within the
+// channel's timeouts. Not getting a response from the channel, or getting a server error, counts against its breaker.
+func (s *Server) forwardRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, channel *models.Channel) error {
+	outcome := forwardAbandoned
+	defer func() { s.breakers.record(channel, outcome, time.Now()) }()
+
 	// parse our channel URL
 	queryPart := ""
 	if r.URL.RawQuery != "" {
@@ -111,13 +282,18 @@ func forwardRequest(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	}
 
 	// create our new outbound request
-	outRequest, err := http.NewRequest(r.Method, outURL.String(), bytes.NewReader([]byte(r.PostForm.Encode())))
+	body := []byte(r.PostForm.Encode())
+	ctx, cancel := context.WithTimeout(ctx, channel.ResponseTimeout())
+	defer cancel()
+
+	outRequest, err := http.NewRequestWithContext(ctx, r.Method, outURL.String(), bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
-	// set any headers
-	outRequest.Header = r.Header
+	// set any headers, and then any authentication our channel requires
+	outRequest.Header = r.Header.Clone()
+	authenticateOutbound(outRequest, channel.Auth, body, time.Now())
 
 	log := slog.With(
 		"channel_uuid", channel.UUID,
@@ -130,31 +306,166 @@ func forwardRequest(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	}
 
 	// fire it off
-	resp, err := client.Do(outRequest)
+	resp, err := s.clients.forChannel(channel).Do(outRequest)
 	if err != nil {
+		// if our own client went away that tells us nothing about our channel
+		if r.Context().Err() == nil {
+			outcome = forwardFailed
+		}
 		log.Error("error fowarding request", "error", err)
 		return err
 	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if r.Context().Err() == nil {
+			outcome = forwardFailed
+		}
+		log.Error("error reading forwarded response", "error", err)
+		return err
+	}
 
 	log.Info("request forwarded", "status_code", resp.StatusCode)
 
+	outcome = forwardSucceeded
+	if resp.StatusCode >= http.StatusInternalServerError {
+		outcome = forwardFailed
+	}
+
 	// we respond in the same way our downstream server did
 	w.WriteHeader(resp.StatusCode)
-	body, err := io.ReadAll(resp.Body)
+	_, err = w.Write(respBody)
+
+	return err
+}
+
+// how far a signed request's timestamp can be from our clock
+const maxSignatureAge = 5 * time.Minute
+
+// the largest request body we will read into memory to verify or route a message, messages are far smaller
+const maxRequestBody = 1 << 20
+
+// readBody reads the body of the passed in request, failing if it is larger than our maximum, and puts it back so
+// that it can be read again
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = w.Write(body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
 
-	return err
+// verifyInbound checks the passed in request against the verification required by its interchange, returning why
+// it failed or an empty string if it passed. HMAC signatures are over the request timestamp and body, or the query
+// string for requests without a body, joined with a period. Tokens are removed from the query once verified so that
+// they aren't forwarded.
+func verifyInbound(w http.ResponseWriter, r *http.Request, auth *models.InterchangeAuth, now time.Time) string {
+	switch auth.Type {
+	case models.InterchangeAuthHMAC:
+		header := auth.Header
+		if header == "" {
+			header = models.DefaultSignatureHeader
+		}
+		signature := r.Header.Get(header)
+		if signature == "" {
+			return "missing signature"
+		}
+
+		timestamp := r.Header.Get("X-Clover-Timestamp")
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return "missing or invalid timestamp"
+		}
+		age := now.Sub(time.Unix(seconds, 0))
+		if age > maxSignatureAge || age < -maxSignatureAge {
+			return "timestamp too far from current time"
+		}
+
+		// read our body so we can check it, it is put back for parsing
+		body, err := readBody(w, r)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "body too large"
+		}
+		if err != nil {
+			return "error reading body"
+		}
+		if len(body) == 0 {
+			body = []byte(r.URL.RawQuery)
+		}
+
+		if !hmac.Equal([]byte(signature), []byte(signBody(auth.Secret, timestamp, body))) {
+			return "invalid signature"
+		}
+
+	case models.InterchangeAuthToken:
+		param := auth.Param
+		if param == "" {
+			param = models.DefaultTokenParam
+		}
+		token := r.URL.Query().Get(param)
+		if token == "" {
+			return "missing token"
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(auth.Token)) != 1 {
+			return "invalid token"
+		}
+		r.URL.RawQuery = removeQueryParam(r.URL.RawQuery, param)
+
+	default:
+		return "unknown verification type"
+	}
+
+	return ""
 }
 
-var client *http.Client
+// removeQueryParam removes the passed in parameter from a raw query string, leaving everything else as it was
+func removeQueryParam(rawQuery string, param string) string {
+	parts := strings.Split(rawQuery, "&")
+	kept := parts[:0]
+	for _, part := range parts {
+		key, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == param {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, "&")
+}
 
-func init() {
-	tr := &http.Transport{
-		MaxIdleConns:    10,
-		IdleConnTimeout: 30 * time.Second,
+// authenticateOutbound adds the passed in channel authentication to an outbound request. HMAC signatures are over
+// the request timestamp and body, joined with a period, and are sent hex encoded.
+func authenticateOutbound(r *http.Request, auth *models.ChannelAuth, body []byte, now time.Time) {
+	if auth == nil {
+		return
 	}
-	client = &http.Client{Transport: tr}
+
+	switch auth.Type {
+	case models.ChannelAuthBasic:
+		r.SetBasicAuth(auth.Username, auth.Password)
+
+	case models.ChannelAuthBearer:
+		r.Header.Set("Authorization", "Bearer "+auth.Token)
+
+	case models.ChannelAuthHMAC:
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		header := auth.Header
+		if header == "" {
+			header = models.DefaultSignatureHeader
+		}
+
+		r.Header.Set("X-Clover-Timestamp", timestamp)
+		r.Header.Set(header, signBody(auth.Secret, timestamp, body))
+	}
+}
+
+// signBody returns the hex encoded HMAC-SHA256 of the passed in timestamp and body
+func signBody(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
 }
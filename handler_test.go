@@ -2,13 +2,18 @@ package clover
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/nyaruka/rp-clover/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -44,6 +49,24 @@ func TestHandler(t *testing.T) {
 	s := setUpTest(t)
 	defer s.Stop()
 
+	testHandler(t)
+}
+
+func TestHandlerWithMemoryStore(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	testHandler(t)
+}
+
+func TestHandlerWithSQLiteStore(t *testing.T) {
+	s := setUpTestWithDB(t, "sqlite:"+t.TempDir()+"/clover.db")
+	defer s.Stop()
+
+	testHandler(t)
+}
+
+func testHandler(t *testing.T) {
 	tsBody := ""
 	tsStatus := 200
 	var tsReq *http.Request
@@ -128,3 +151,939 @@ func TestHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestDrain(t *testing.T) {
+	config := NewConfig()
+	config.DB = "memory:"
+	config.DrainDelay = 1
+	s := NewServer(config, http.Dir("static"))
+	err := s.Start()
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// a downstream server which takes a while to respond
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(1500 * time.Millisecond)
+		resp.Write([]byte("handled"))
+	}))
+	defer server.Close()
+
+	interchanges := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	err = makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{interchanges}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	forwarded := make(chan error)
+	go func() {
+		forwarded <- makeTestRequest("/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?sender=2065551212&message=test", http.MethodGet, nil, false, 200, "handled")
+	}()
+
+	select {
+	case <-started:
+	case err := <-forwarded:
+		t.Fatalf("forward finished before reaching downstream: %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	// while we wait out our drain delay we report not ready but still serve requests
+	time.Sleep(100 * time.Millisecond)
+	err = makeTestRequest("/ready", http.MethodGet, nil, false, 503, `"status":"draining"`)
+	assert.NoError(t, err)
+
+	// our in-flight forward is allowed to finish before we stop
+	assert.NoError(t, <-forwarded)
+	<-stopped
+}
+
+func TestHandlerAuth(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	var tsReq *http.Request
+	var tsBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		tsReq = req
+		tsBody, _ = io.ReadAll(req.Body)
+		resp.Write([]byte("handled"))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, `"url": "https://handler1",`, `"url": "`+server.URL+`/handler1", "auth": {"type": "bearer", "token": "abc123"},`, 1)
+	config = strings.Replace(config, `"url": "https://handler2",`, `"url": "`+server.URL+`/handler2", "auth": {"type": "hmac", "secret": "sesame", "header": "X-Signature"},`, 1)
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{config}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	// our secrets aren't shown, even in our audit log
+	for _, path := range []string{"/admin", "/admin/audit"} {
+		err = makeTestRequest(path, http.MethodGet, nil, true, 200, "********")
+		assert.NoError(t, err)
+		err = makeTestRequest(path, http.MethodGet, nil, true, 200, "sesame")
+		assert.Error(t, err, "secret found on %s", path)
+	}
+
+	// resaving our redacted config keeps our secrets
+	redacted := strings.Replace(config, "abc123", "********", 1)
+	redacted = strings.Replace(redacted, `"secret": "sesame"`, `"secret": "********"`, 1)
+	err = makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{redacted}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	err = makeTestRequest("/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?sender=2065551212&message=test", http.MethodGet, nil, false, 200, "handled")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer abc123", tsReq.Header.Get("Authorization"))
+
+	err = makeTestRequest("/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive", http.MethodPost, url.Values{"sender": []string{"2065551212"}, "message": []string{"two"}}, false, 200, "handled")
+	assert.NoError(t, err)
+	assert.Equal(t, "", tsReq.Header.Get("Authorization"))
+
+	timestamp := tsReq.Header.Get("X-Clover-Timestamp")
+	assert.NotEqual(t, "", timestamp)
+	assert.Equal(t, signBody("sesame", timestamp, tsBody), tsReq.Header.Get("X-Signature"))
+}
+
+func TestAuthenticateOutbound(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	r, _ := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	authenticateOutbound(r, &models.ChannelAuth{Type: models.ChannelAuthBasic, Username: "bob", Password: "sesame"}, nil, now)
+	username, password, ok := r.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "bob", username)
+	assert.Equal(t, "sesame", password)
+
+	r, _ = http.NewRequest(http.MethodPost, "http://example.com", nil)
+	authenticateOutbound(r, &models.ChannelAuth{Type: models.ChannelAuthHMAC, Secret: "sesame"}, []byte("sender=1234"), now)
+	assert.Equal(t, "1714564800", r.Header.Get("X-Clover-Timestamp"))
+	assert.Equal(t, "a3403e9642efd4b487ebaf6512494971f8ee4bbed6e4dcfe245501161e5f6482", r.Header.Get(models.DefaultSignatureHeader))
+
+	r, _ = http.NewRequest(http.MethodPost, "http://example.com", nil)
+	authenticateOutbound(r, nil, nil, now)
+	assert.Equal(t, 0, len(r.Header))
+}
+
+func TestHandlerVerification(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	var tsReq *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		tsReq = req
+		resp.Write([]byte("handled"))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	tokenConfig := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "auth": {"type": "token", "token": "abc123", "param": "key"},`, 1)
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{tokenConfig}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	// our token isn't shown
+	err = makeTestRequest("/admin", http.MethodGet, nil, true, 200, "abc123")
+	assert.Error(t, err)
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive"
+
+	// requests without a valid token are rejected before any keyword mapping is made
+	err = makeTestRequest(receive+"?sender=2065551212&message=two", http.MethodGet, nil, false, 401, "request verification failed")
+	assert.NoError(t, err)
+	err = makeTestRequest(receive+"?sender=2065551212&message=two&key=abc124", http.MethodGet, nil, false, 401, "request verification failed")
+	assert.NoError(t, err)
+
+	// our token is stripped before forwarding, and our rejected keyword didn't move us to handler2
+	tsReq = nil
+	err = makeTestRequest(receive+"?sender=2065551212&message=hi&key=abc123&other=foo", http.MethodGet, nil, false, 200, "handled")
+	assert.NoError(t, err)
+	if assert.NotNil(t, tsReq) {
+		assert.Equal(t, "/handler1?sender=2065551212&message=hi&other=foo", tsReq.URL.String())
+	}
+
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"inbound_rejected":{"5fb66333-7f8c-47aa-9aa5-bfee37b79b22":2}`)
+	assert.NoError(t, err)
+
+	// switch to requiring signatures
+	hmacConfig := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "auth": {"type": "hmac", "secret": "sesame"},`, 1)
+	err = makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{hmacConfig}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	post := func(body string, timestamp time.Time, secret string) int {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:8081"+receive, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		req.Header.Set("X-Clover-Timestamp", ts)
+		req.Header.Set("X-Clover-Signature", signBody(secret, ts, []byte(body)))
+
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	body := "sender=2065551212&message=hello"
+	assert.Equal(t, 200, post(body, time.Now(), "sesame"))
+	assert.Equal(t, 401, post(body, time.Now(), "wrong"))
+	assert.Equal(t, 401, post(body, time.Now().Add(-10*time.Minute), "sesame"))
+	assert.Equal(t, 200, post(body, time.Now().Add(time.Minute), "sesame"))
+
+	// bodies too large to read into memory are rejected even if they are signed
+	assert.Equal(t, 401, post(body+strings.Repeat("o", maxRequestBody), time.Now(), "sesame"))
+
+	err = makeTestRequest(receive+"?sender=2065551212&message=hello", http.MethodGet, nil, false, 401, "request verification failed")
+	assert.NoError(t, err)
+}
+
+func TestRemoveQueryParam(t *testing.T) {
+	assert.Equal(t, "a=1&c=3", removeQueryParam("a=1&token=2&c=3", "token"))
+	assert.Equal(t, "a=1", removeQueryParam("a=1&token=2&token=3", "token"))
+	assert.Equal(t, "tokens=1&a=%20", removeQueryParam("tokens=1&a=%20&to%6Ben=2", "token"))
+	assert.Equal(t, "", removeQueryParam("token", "token"))
+}
+
+func TestHandlerAllowedIPs(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte("handled"))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "allowed_ips": ["10.0.0.0/8", "2001:db8::1"],`, 1)
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{config}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	invalid := strings.Replace(config, `"2001:db8::1"`, `"10.0.0.300"`, 1)
+	err = makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{invalid}}, true, 200, "invalid network &#39;10.0.0.300&#39;")
+	assert.NoError(t, err)
+
+	// we come from localhost, which is a trusted proxy, so our forwarding headers say who our client is
+	receive := func(forwardedFor string) int {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8081/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?sender=2065551212&message=test", nil)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, 403, receive(""))
+	assert.Equal(t, 200, receive("10.1.2.3"))
+	assert.Equal(t, 200, receive("2001:db8::1"))
+	assert.Equal(t, 403, receive("2001:db8::2"))
+
+	// a client can't claim to be an allowed address by adding it ahead of its real one
+	assert.Equal(t, 403, receive("10.1.2.3, 192.168.1.1"))
+	assert.Equal(t, 200, receive("192.168.1.1, 10.1.2.3, 127.0.0.1"))
+
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"inbound_blocked":{"5fb66333-7f8c-47aa-9aa5-bfee37b79b22":3}`)
+	assert.NoError(t, err)
+}
+
+func TestHandlerRateLimits(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	forwarded := 0
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		forwarded++
+		resp.Write([]byte("handled"))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	limited := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "rate_limit": {"urn": {"rate": 0.001, "burst": 2}, "interchange": {"rate": 0.001, "burst": 3}},`, 1)
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{limited}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=test&sender="
+
+	// each sender gets its own limit, and the interchange has an overall limit
+	assert.NoError(t, makeTestRequest(receive+"2065551212", http.MethodGet, nil, false, 200, "handled"))
+	assert.NoError(t, makeTestRequest(receive+"2065551212", http.MethodGet, nil, false, 200, "handled"))
+	assert.NoError(t, makeTestRequest(receive+"2065551212", http.MethodGet, nil, false, 429, "rate limit exceeded"))
+	assert.NoError(t, makeTestRequest(receive+"2065551213", http.MethodGet, nil, false, 200, "handled"))
+	assert.NoError(t, makeTestRequest(receive+"2065551214", http.MethodGet, nil, false, 429, "rate limit exceeded"))
+	assert.Equal(t, 3, forwarded)
+
+	// when dropping, requests over our limit look like they succeeded but aren't forwarded
+	dropped := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "rate_limit": {"urn": {"rate": 0.001, "burst": 1}, "action": "drop"},`, 1)
+	err = makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{dropped}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	assert.NoError(t, makeTestRequest(receive+"2065551215", http.MethodGet, nil, false, 200, "handled"))
+	assert.NoError(t, makeTestRequest(receive+"2065551215", http.MethodGet, nil, false, 200, ""))
+	assert.Equal(t, 4, forwarded)
+
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"inbound_limited":{"5fb66333-7f8c-47aa-9aa5-bfee37b79b22":3}`)
+	assert.NoError(t, err)
+
+	invalid := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "rate_limit": {"action": "drop"},`, 1)
+	err = makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{invalid}}, true, 200, "rate limit must limit URNs, the interchange or both")
+	assert.NoError(t, err)
+}
+
+func TestHandlerDedup(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	forwarded := 0
+	status := 201
+	var release chan struct{}
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		forwarded++
+		if release != nil {
+			<-release
+		}
+		resp.WriteHeader(status)
+		resp.Write([]byte(fmt.Sprintf("handled %d", forwarded)))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	byID := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "dedup": {"field": "id", "window": 300},`, 1)
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{byID}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?sender=2065551212&message=test"
+
+	// retries of a message get the original response without being forwarded again
+	assert.NoError(t, makeTestRequest(receive+"&id=1", http.MethodGet, nil, false, 201, "handled 1"))
+	assert.NoError(t, makeTestRequest(receive+"&id=1", http.MethodGet, nil, false, 201, "handled 1"))
+	assert.NoError(t, makeTestRequest(receive+"&id=2", http.MethodGet, nil, false, 201, "handled 2"))
+	assert.Equal(t, 2, forwarded)
+
+	// messages without an ID can't be deduplicated
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 201, "handled 3"))
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 201, "handled 4"))
+
+	// a retry while the original is still being forwarded is refused
+	release = make(chan struct{})
+	done := make(chan error)
+	go func() { done <- makeTestRequest(receive+"&id=3", http.MethodGet, nil, false, 201, "handled 5") }()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, makeTestRequest(receive+"&id=3", http.MethodGet, nil, false, 409, "duplicate message still being forwarded"))
+	close(release)
+	assert.NoError(t, <-done)
+	release = nil
+	assert.NoError(t, makeTestRequest(receive+"&id=3", http.MethodGet, nil, false, 201, "handled 5"))
+
+	// server errors aren't remembered, so a retry after one is forwarded again
+	status = 500
+	assert.NoError(t, makeTestRequest(receive+"&id=6", http.MethodGet, nil, false, 500, "handled 6"))
+	status = 201
+	assert.NoError(t, makeTestRequest(receive+"&id=6", http.MethodGet, nil, false, 201, "handled 7"))
+	assert.NoError(t, makeTestRequest(receive+"&id=6", http.MethodGet, nil, false, 201, "handled 7"))
+	assert.Equal(t, 7, forwarded)
+
+	// without a field, messages are identified by their sender and text
+	byHash := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "dedup": {"window": 300},`, 1)
+	err = makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{byHash}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	assert.NoError(t, makeTestRequest(receive+"&id=4", http.MethodGet, nil, false, 201, "handled 8"))
+	assert.NoError(t, makeTestRequest(receive+"&id=5", http.MethodGet, nil, false, 201, "handled 8"))
+	assert.NoError(t, makeTestRequest(strings.Replace(receive, "test", "other", 1), http.MethodGet, nil, false, 201, "handled 9"))
+
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"inbound_duplicates":{"5fb66333-7f8c-47aa-9aa5-bfee37b79b22":5}`)
+	assert.NoError(t, err)
+
+	// if we can't forward a message, a retry of it is forwarded
+	server.Close()
+	down := strings.Replace(receive, "2065551212", "2065551213", 1)
+	assert.NoError(t, makeTestRequest(down, http.MethodGet, nil, false, 500, ""))
+	assert.NoError(t, makeTestRequest(down, http.MethodGet, nil, false, 500, ""))
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"inbound_duplicates":{"5fb66333-7f8c-47aa-9aa5-bfee37b79b22":5}`)
+	assert.NoError(t, err)
+}
+
+func TestHandlerBreakers(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	var handler1 func(resp http.ResponseWriter)
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/handler1" {
+			handler1(resp)
+			return
+		}
+		resp.Write([]byte("handler2"))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	failover := strings.Replace(config, `"name": "Handler1",`, `"name": "Handler1", "forwarding": {"response_timeout": 1, "breaker_failures": 2, "breaker_cooldown": 1, "failover": "3d0cd397-2228-4185-86db-7e3272fc423e"},`, 1)
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{failover}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?sender=2065551212&message=test"
+
+	// server errors open our breaker, after which we fail over to the other channel
+	handler1 = func(resp http.ResponseWriter) { resp.WriteHeader(http.StatusBadGateway) }
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 502, ""))
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 502, ""))
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 200, "handler2"))
+
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"forward_failovers":{"557d3353-6b89-441a-aee5-8c398fd7a61f":1}`)
+	assert.NoError(t, err)
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"uuid":"557d3353-6b89-441a-aee5-8c398fd7a61f","name":"Handler1","state":"open","failures":2`)
+	assert.NoError(t, err)
+	assert.NoError(t, makeTestRequest("/admin", http.MethodGet, nil, true, 200, "<td>open</td>"))
+
+	// once cooled down a trial request is let through, and closes our breaker if it succeeds
+	handler1 = func(resp http.ResponseWriter) { resp.Write([]byte("handler1")) }
+	time.Sleep(1100 * time.Millisecond)
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"breakers":[]`))
+	assert.NoError(t, makeTestRequest("/admin", http.MethodGet, nil, true, 200, "All channels are healthy"))
+
+	// requests which time out count as failures, and without a failover we fail fast while our breaker is open
+	handler1 = func(resp http.ResponseWriter) { time.Sleep(1500 * time.Millisecond) }
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 500, ""))
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 500, ""))
+
+	noFailover := strings.Replace(config, `"name": "Handler1",`, `"name": "Handler1", "forwarding": {"breaker_failures": 2},`, 1)
+	err = makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{noFailover}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	assert.NoError(t, makeTestRequest(receive, http.MethodGet, nil, false, 503, "channel unavailable"))
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"forward_unavailable":{"557d3353-6b89-441a-aee5-8c398fd7a61f":1}`)
+	assert.NoError(t, err)
+}
+
+func TestHandlerHealthChecks(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	health1, health2 := http.StatusOK, http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/health1":
+			resp.WriteHeader(health1)
+		case "/health2":
+			assert.Equal(t, http.MethodHead, req.Method)
+			resp.WriteHeader(health2)
+		default:
+			resp.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+		}
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	config = strings.Replace(config, `"name": "Handler1",`, `"name": "Handler1", "health_check": {"url": "`+server.URL+`/health1"},`, 1)
+	config = strings.Replace(config, `"name": "Handler2",`, `"name": "Handler2", "health_check": {"url": "`+server.URL+`/health2", "method": "HEAD", "status": 204},`, 1)
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{config}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=test&sender="
+	ctx := context.Background()
+
+	assert.NoError(t, s.checkHealth(ctx))
+	assert.NoError(t, makeTestRequest(receive+"2065551212", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(strings.Replace(receive, "test", "one", 1)+"2065551213", http.MethodGet, nil, false, 200, "handler1"))
+
+	// once our default channel is unhealthy, unmapped senders go to a healthy channel but mapped senders don't move
+	health1 = http.StatusServiceUnavailable
+	assert.NoError(t, s.checkHealth(ctx))
+	assert.NoError(t, makeTestRequest(receive+"2065551212", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"2065551213", http.MethodGet, nil, false, 200, "handler1"))
+
+	assert.NoError(t, makeTestRequest("/admin/health", http.MethodGet, nil, true, 200, `"uuid":"557d3353-6b89-441a-aee5-8c398fd7a61f","name":"Handler1","healthy":false`))
+	assert.NoError(t, makeTestRequest("/admin/health/557D3353-6B89-441A-AEE5-8C398FD7A61F", http.MethodGet, nil, true, 200, `"healthy":false,"status_code":503,"error":"expected status 200"`))
+	assert.NoError(t, makeTestRequest("/admin/health/557d3353-6b89-441a-aee5-8c398fd7a61f?limit=0", http.MethodGet, nil, true, 400, "invalid limit"))
+	assert.NoError(t, makeTestRequest("/admin/health/7331140b-2be0-4855-92e1-fd06ca456364", http.MethodGet, nil, true, 404, "channel not found"))
+	assert.NoError(t, makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"channels_unhealthy":["557d3353-6b89-441a-aee5-8c398fd7a61f"]`))
+	assert.NoError(t, makeTestRequest("/admin/health", http.MethodGet, nil, true, 200, `"changes":0},{"uuid":"557d3353-6b89-441a-aee5-8c398fd7a61f"`))
+	assert.NoError(t, makeTestRequest("/admin/health", http.MethodGet, nil, true, 200, `"changes":1}]`))
+	assert.NoError(t, makeTestRequest("/admin/metrics", http.MethodGet, nil, true, 200, `clover_channel_healthy{channel_uuid="557d3353-6b89-441a-aee5-8c398fd7a61f",name="Handler1"} 0`))
+	assert.NoError(t, makeTestRequest("/admin/metrics", http.MethodGet, nil, false, 401, ""))
+
+	// if every channel is unhealthy we stick with our default
+	health2 = http.StatusOK
+	assert.NoError(t, s.checkHealth(ctx))
+	assert.NoError(t, makeTestRequest(receive+"2065551212", http.MethodGet, nil, false, 200, "handler1"))
+
+	// and once it recovers unmapped senders go back to it
+	health1, health2 = http.StatusOK, http.StatusNoContent
+	assert.NoError(t, s.checkHealth(ctx))
+	assert.NoError(t, makeTestRequest(receive+"2065551214", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"channels_unhealthy":[]`))
+	assert.NoError(t, makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"health_changes":{"3d0cd397-2228-4185-86db-7e3272fc423e":2,"557d3353-6b89-441a-aee5-8c398fd7a61f":2}`))
+	assert.NoError(t, makeTestRequest("/admin/health/557d3353-6b89-441a-aee5-8c398fd7a61f?limit=1", http.MethodGet, nil, true, 200, `"healthy":true,"status_code":200`))
+
+	// our metrics report the same
+	metrics := `clover_channel_healthy{channel_uuid="3d0cd397-2228-4185-86db-7e3272fc423e",name="Handler2"} 1
+clover_channel_healthy{channel_uuid="557d3353-6b89-441a-aee5-8c398fd7a61f",name="Handler1"} 1
+# HELP clover_channel_health_changes_total How many times the health of a channel has changed.
+# TYPE clover_channel_health_changes_total counter
+clover_channel_health_changes_total{channel_uuid="3d0cd397-2228-4185-86db-7e3272fc423e"} 2
+clover_channel_health_changes_total{channel_uuid="557d3353-6b89-441a-aee5-8c398fd7a61f"} 2
+`
+	assert.NoError(t, makeTestRequest("/admin/metrics", http.MethodGet, nil, true, 200, metrics))
+
+	// when another instance is probing our channels we use the results it records instead of probing them ourselves
+	s.store = &probedElsewhereStore{s.store}
+	health1 = http.StatusServiceUnavailable
+	result := &models.HealthCheckResult{ChannelUUID: "3d0cd397-2228-4185-86db-7e3272fc423e", Healthy: false, StatusCode: 500, Error: "expected status 204", CheckedOn: time.Now()}
+	assert.NoError(t, s.store.InsertHealthCheck(ctx, result))
+	assert.NoError(t, s.checkHealth(ctx))
+	assert.NoError(t, makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"channels_unhealthy":["3d0cd397-2228-4185-86db-7e3272fc423e"]`))
+	assert.NoError(t, makeTestRequest("/admin/health/557d3353-6b89-441a-aee5-8c398fd7a61f?limit=1", http.MethodGet, nil, true, 200, `"healthy":true,"status_code":200`))
+
+	// and results we have already seen aren't counted as changes again
+	assert.NoError(t, s.checkHealth(ctx))
+	assert.NoError(t, makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"health_changes":{"3d0cd397-2228-4185-86db-7e3272fc423e":3,"557d3353-6b89-441a-aee5-8c398fd7a61f":2}`))
+}
+
+// probedElsewhereStore is a store where another instance always has our health checks
+type probedElsewhereStore struct {
+	models.Store
+}
+
+func (s *probedElsewhereStore) ClaimHealthChecks(ctx context.Context, since time.Time) (func(), bool, error) {
+	return nil, false, nil
+}
+
+func TestPickWeighted(t *testing.T) {
+	channels := []*models.Channel{{UUID: "a", Weight: 2}, {UUID: "b"}, {UUID: "c", Weight: 3}}
+
+	picked := make([]string, 0)
+	for n := 0; n < 6; n++ {
+		picked = append(picked, pickWeighted(channels, n).UUID)
+	}
+	assert.Equal(t, []string{"a", "a", "b", "c", "c", "c"}, picked)
+}
+
+func TestHandlerDistribution(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	setDistribution := func(distribution string, handler1 string, handler2 string) {
+		c := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "distribution": "`+distribution+`",`, 1)
+		c = strings.Replace(c, `"name": "Handler1",`, `"name": "Handler1", `+handler1, 1)
+		c = strings.Replace(c, `"name": "Handler2",`, `"name": "Handler2", `+handler2, 1)
+		assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{c}}, true, 200, "configuration saved"))
+	}
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=test&sender="
+	mappings := "/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/mappings/counts"
+
+	// by default senders all go to our default channel and aren't mapped
+	setDistribution("default", "", "")
+	assert.NoError(t, makeTestRequest(receive+"2065550000", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(mappings, http.MethodGet, nil, true, 200, `"count":0`))
+
+	// round robin alternates, and senders stay with the channel they were assigned
+	setDistribution("round_robin", "", "")
+	for i, expected := range []string{"handler1", "handler2", "handler1", "handler2"} {
+		assert.NoError(t, makeTestRequest(receive+"206555000"+strconv.Itoa(i+1), http.MethodGet, nil, false, 200, expected))
+	}
+	assert.NoError(t, makeTestRequest(receive+"2065550001", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(receive+"2065550002", http.MethodGet, nil, false, 200, "handler2"))
+
+	// fill assigns senders to the first channel with room
+	setDistribution("fill", `"capacity": 3,`, "")
+	assert.NoError(t, makeTestRequest(receive+"2065550005", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(receive+"2065550006", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"2065550007", http.MethodGet, nil, false, 200, "handler2"))
+
+	// weighted assigns senders in proportion to channel weights
+	setDistribution("weighted", `"weight": 1,`, `"weight": 1000000,`)
+	assert.NoError(t, makeTestRequest(receive+"2065550008", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"2065550001", http.MethodGet, nil, false, 200, "handler1"))
+
+	assert.NoError(t, makeTestRequest(mappings, http.MethodGet, nil, true, 200, `{"channel_uuid":"3d0cd397-2228-4185-86db-7e3272fc423e","count":5}`))
+
+	// strategies must be one we know
+	c := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "distribution": "random",`, 1)
+	assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{c}}, true, 200, "Distribution"))
+
+	// and weighted distribution needs every channel to have a weight
+	c = strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "distribution": "weighted",`, 1)
+	c = strings.Replace(c, `"name": "Handler1",`, `"name": "Handler1", "weight": 2,`, 1)
+	assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{c}}, true, 200, "channel 3d0cd397-2228-4185-86db-7e3272fc423e needs a weight of at least 1 for weighted distribution"))
+}
+
+func TestPickHashed(t *testing.T) {
+	channels := []*models.Channel{
+		{UUID: "557d3353-6b89-441a-aee5-8c398fd7a61f"},
+		{UUID: "3d0cd397-2228-4185-86db-7e3272fc423e"},
+		{UUID: "7331140b-2be0-4855-92e1-fd06ca456364"},
+	}
+	added := append([]*models.Channel{{UUID: "afc2532c-1565-4016-a83e-fc6bc1ac3550"}}, channels...)
+	removed := []*models.Channel{channels[0], channels[2]}
+
+	assign := func(channels []*models.Channel) map[string]string {
+		assigned := make(map[string]string)
+		for i := 0; i < 3000; i++ {
+			urn := fmt.Sprintf("tel:+250788%06d", i)
+			assigned[urn] = pickHashed(channels, urn).UUID
+		}
+		return assigned
+	}
+	shares := func(assigned map[string]string) map[string]int {
+		counts := make(map[string]int)
+		for _, uuid := range assigned {
+			counts[uuid]++
+		}
+		return counts
+	}
+
+	// URNs are spread evenly and always hash to the same channel
+	before := assign(channels)
+	assert.Equal(t, before, assign(channels))
+	for _, count := range shares(before) {
+		assert.InDelta(t, 1000, count, 150)
+	}
+
+	// adding a channel only moves URNs to it
+	moved := 0
+	for urn, uuid := range assign(added) {
+		if uuid != before[urn] {
+			assert.Equal(t, added[0].UUID, uuid)
+			moved++
+		}
+	}
+	assert.InDelta(t, 750, moved, 150)
+
+	// removing a channel only moves the URNs it had
+	for urn, uuid := range assign(removed) {
+		if uuid != before[urn] {
+			assert.Equal(t, channels[1].UUID, before[urn])
+		}
+	}
+
+	// and channels get shares in proportion to their weights
+	weighted := []*models.Channel{{UUID: channels[0].UUID, Weight: 2}, channels[1]}
+	assert.InDelta(t, 2000, shares(assign(weighted))[channels[0].UUID], 150)
+}
+
+func TestHandlerHashDistribution(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	config = strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "distribution": "hash",`, 1)
+	assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{config}}, true, 200, "configuration saved"))
+
+	interchange, err := s.store.GetInterchange(context.Background(), "5fb66333-7f8c-47aa-9aa5-bfee37b79b22")
+	assert.NoError(t, err)
+	channels := []*models.Channel{&interchange.Channels[0], &interchange.Channels[1]}
+
+	// senders go to the channel they hash to without being mapped
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=test&sender="
+	urns := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		sender := fmt.Sprintf("25078800000%d", i)
+		urns = append(urns, "tel:+"+sender)
+		expected := strings.TrimPrefix(pickHashed(channels, "tel:+"+sender).URL, server.URL+"/")
+		assert.NoError(t, makeTestRequest(receive+sender, http.MethodGet, nil, false, 200, expected))
+		assert.NoError(t, makeTestRequest(receive+sender, http.MethodGet, nil, false, 200, expected))
+	}
+	counts := "/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/mappings/counts"
+	assert.NoError(t, makeTestRequest(counts, http.MethodGet, nil, true, 200, `"count":0},{"channel_uuid":"557d3353-6b89-441a-aee5-8c398fd7a61f","count":0}`))
+
+	// but keywords still map senders
+	assert.NoError(t, makeTestRequest(strings.Replace(receive, "test", "two", 1)+"250788000000", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"250788000000", http.MethodGet, nil, false, 200, "handler2"))
+
+	// our report shows which senders would move if we removed our first channel
+	moved := make([]string, 0)
+	for _, urn := range urns[1:] {
+		if pickHashed(channels, urn) == channels[0] {
+			moved = append(moved, fmt.Sprintf(`{"urn":"%s","from":"557d3353-6b89-441a-aee5-8c398fd7a61f","to":"3d0cd397-2228-4185-86db-7e3272fc423e"}`, urn))
+		}
+	}
+	report := url.Values{"channels": []string{`[{"uuid": "3D0CD397-2228-4185-86DB-7E3272FC423E"}]`}, "urns": []string{strings.Join(urns, "\n")}}
+	path := "/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/hash/report"
+	assert.NoError(t, makeTestRequest(path, http.MethodPost, report, true, 200, `{"urns":10,"pinned":1,"moved":[`+strings.Join(moved, ",")+`]}`))
+
+	report.Set("channels", "[]")
+	assert.NoError(t, makeTestRequest(path, http.MethodPost, report, true, 400, "invalid channels"))
+
+	// senders whose channel is unhealthy fail over to the other, everyone else stays where they hash to
+	s.health.update(channels[0], &models.HealthCheckResult{ChannelUUID: channels[0].UUID, Healthy: false, CheckedOn: time.Now()})
+	for i := 1; i < len(urns); i++ {
+		assert.NoError(t, makeTestRequest(receive+fmt.Sprintf("25078800000%d", i), http.MethodGet, nil, false, 200, "handler2"))
+	}
+
+	// and go back once it recovers
+	s.health.update(channels[0], &models.HealthCheckResult{ChannelUUID: channels[0].UUID, Healthy: true, CheckedOn: time.Now()})
+	for i := 1; i < len(urns); i++ {
+		expected := strings.TrimPrefix(pickHashed(channels, urns[i]).URL, server.URL+"/")
+		assert.NoError(t, makeTestRequest(receive+fmt.Sprintf("25078800000%d", i), http.MethodGet, nil, false, 200, expected))
+	}
+}
+
+func TestHandlerChannelCapacity(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	setCapacities := func(handler1 string, handler2 string) {
+		c := strings.Replace(config, `"name": "Handler1",`, `"name": "Handler1", `+handler1+`,`, 1)
+		c = strings.Replace(c, `"name": "Handler2",`, `"name": "Handler2", `+handler2+`,`, 1)
+		assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{c}}, true, 200, "configuration saved"))
+	}
+	setCapacities(`"capacity": 1, "when_full": {"overflow": "3d0cd397-2228-4185-86db-7e3272fc423e"}`, `"capacity": 1, "when_full": {"status": 429, "body": "we're full"}`)
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=test&sender="
+	keyword := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=one&sender="
+
+	// senders going to our default channel are mapped so they count towards its capacity, and can stay once it is full
+	assert.NoError(t, makeTestRequest(receive+"2065550001", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(receive+"2065550001", http.MethodGet, nil, false, 200, "handler1"))
+
+	// new senders overflow to the next channel until that is full too, then are refused with its response
+	assert.NoError(t, makeTestRequest(receive+"2065550002", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"2065550003", http.MethodGet, nil, false, 429, "we're full"))
+
+	// keyword joins overflow the same way, but senders can always rejoin the channel they are in
+	assert.NoError(t, makeTestRequest(keyword+"2065550004", http.MethodGet, nil, false, 429, "we're full"))
+	assert.NoError(t, makeTestRequest(strings.Replace(keyword, "one", "two", 1)+"2065550002", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(keyword+"2065550001", http.MethodGet, nil, false, 200, "handler1"))
+
+	err := makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"assign_overflows":{"3d0cd397-2228-4185-86db-7e3272fc423e":2,"557d3353-6b89-441a-aee5-8c398fd7a61f":3}`)
+	assert.NoError(t, err)
+	err = makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"assign_refusals":{"3d0cd397-2228-4185-86db-7e3272fc423e":2}`)
+	assert.NoError(t, err)
+	assert.NoError(t, makeTestRequest("/admin", http.MethodGet, nil, true, 200, "overflow to 3d0cd397-2228-4185-86db-7e3272fc423e"))
+
+	// without a configured response, refusals are a 403 error
+	setCapacities(`"capacity": 1`, `"capacity": 5`)
+	assert.NoError(t, makeTestRequest(receive+"2065550005", http.MethodGet, nil, false, 403, "channel full"))
+	assert.NoError(t, makeTestRequest(keyword+"2065550005", http.MethodGet, nil, false, 403, "channel full"))
+	assert.NoError(t, makeTestRequest(strings.Replace(keyword, "one", "two", 1)+"2065550005", http.MethodGet, nil, false, 200, "handler2"))
+}
+
+func TestHandlerSchedules(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	setSchedule := func(schedule string) {
+		c := strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "timezone": "Africa/Lagos", "schedules": [`+schedule+`],`, 1)
+		assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{c}}, true, 200, "configuration saved"))
+	}
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=test&sender="
+	keyword := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=one&sender="
+
+	// while our schedule is open, messages are routed as usual
+	always := `{"days": ["mon", "tue", "wed", "thu", "fri", "sat", "sun"], "start": "00:00", "end": "24:00"}`
+	setSchedule(`{"name": "Office Hours", "channel": "3d0cd397-2228-4185-86db-7e3272fc423e", "windows": [` + always + `]}`)
+	assert.NoError(t, makeTestRequest(receive+"2065550001", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(keyword+"2065550002", http.MethodGet, nil, false, 200, "handler1"))
+
+	// once it is closed, even mapped senders go to its channel
+	setSchedule(`{"name": "Office Hours", "channel": "3d0cd397-2228-4185-86db-7e3272fc423e", "windows": []}`)
+	assert.NoError(t, makeTestRequest(receive+"2065550001", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"2065550002", http.MethodGet, nil, false, 200, "handler2"))
+
+	// unless it only applies to unmapped senders
+	setSchedule(`{"name": "Office Hours", "channel": "3d0cd397-2228-4185-86db-7e3272fc423e", "scope": "unmapped", "windows": []}`)
+	assert.NoError(t, makeTestRequest(receive+"2065550001", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"2065550002", http.MethodGet, nil, false, 200, "handler1"))
+
+	// and exceptions close it on holidays, allowing for today being different in our timezone
+	now := time.Now().UTC()
+	holidays := fmt.Sprintf(`[{"date": "%s"}, {"date": "%s"}, {"date": "%s"}]`, now.AddDate(0, 0, -1).Format("2006-01-02"), now.Format("2006-01-02"), now.AddDate(0, 0, 1).Format("2006-01-02"))
+	setSchedule(`{"name": "Office Hours", "channel": "3d0cd397-2228-4185-86db-7e3272fc423e", "windows": [` + always + `], "exceptions": ` + holidays + `}`)
+	assert.NoError(t, makeTestRequest(receive+"2065550003", http.MethodGet, nil, false, 200, "handler2"))
+
+	// keyword joins always go to the channel they join, but after that the sender is mapped like any other
+	setSchedule(`{"name": "Office Hours", "channel": "3d0cd397-2228-4185-86db-7e3272fc423e", "windows": []}`)
+	assert.NoError(t, makeTestRequest(keyword+"2065550004", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(receive+"2065550004", http.MethodGet, nil, false, 200, "handler2"))
+
+	// senders routed by a prefix rule aren't unmapped
+	config = strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "prefix_rules": [{"prefix": "25078", "channel": "557d3353-6b89-441a-aee5-8c398fd7a61f"}],`, 1)
+	setSchedule(`{"name": "Office Hours", "channel": "3d0cd397-2228-4185-86db-7e3272fc423e", "scope": "unmapped", "windows": []}`)
+	assert.NoError(t, makeTestRequest(receive+"250788000005", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(receive+"250728000005", http.MethodGet, nil, false, 200, "handler2"))
+
+	err := makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"schedule_closed":{"5fb66333-7f8c-47aa-9aa5-bfee37b79b22":6}`)
+	assert.NoError(t, err)
+}
+
+func TestHandlerPrefixRules(t *testing.T) {
+	s := setUpTestWithDB(t, "memory:")
+	defer s.Stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+	}))
+	defer server.Close()
+
+	config := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	config = strings.Replace(config, "https://handler2", server.URL+"/handler2", -1)
+	config = strings.Replace(config, `"scheme": "tel",`, `"scheme": "tel", "prefix_rules": [{"prefix": "25078", "channel": "3d0cd397-2228-4185-86db-7e3272fc423e"}],`, 1)
+	assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{config}}, true, 200, "configuration saved"))
+
+	receive := "/i/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/receive?message=test&sender="
+
+	// senders in the range of a prefix go to its channel without being mapped, others fall back to our default
+	assert.NoError(t, makeTestRequest(receive+"250788000001", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"%2B250788000002", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest(receive+"250728000001", http.MethodGet, nil, false, 200, "handler1"))
+	counts := "/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/mappings/counts"
+	assert.NoError(t, makeTestRequest(counts, http.MethodGet, nil, true, 200, `"count":0},{"channel_uuid":"557d3353-6b89-441a-aee5-8c398fd7a61f","count":0}`))
+
+	// but keywords and mappings come first
+	assert.NoError(t, makeTestRequest(strings.Replace(receive, "test", "one", 1)+"250788000001", http.MethodGet, nil, false, 200, "handler1"))
+	assert.NoError(t, makeTestRequest(receive+"250788000001", http.MethodGet, nil, false, 200, "handler1"))
+
+	// overlapping rules are rejected
+	overlapping := strings.Replace(config, `"prefix_rules": [`, `"prefix_rules": [{"prefix": "250788", "channel": "557d3353-6b89-441a-aee5-8c398fd7a61f"}, `, 1)
+	assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{overlapping}}, true, 200, "prefixes &#43;250788 and &#43;25078 overlap"))
+}
+
+func TestSenderCountries(t *testing.T) {
+	assert.Equal(t, []string{"RW"}, senderCountries("+250788123456"))
+	assert.Equal(t, []string{"NE"}, senderCountries("22790123456"))
+	assert.Equal(t, []string{"EG"}, senderCountries("201001234567"))
+
+	// countries which share a calling code are told apart by their number ranges
+	assert.Equal(t, []string{"US"}, senderCountries("+12065551212"))
+	assert.Equal(t, []string{"CA"}, senderCountries("+14165551212"))
+	assert.Equal(t, []string{"JM"}, senderCountries("+18765551212"))
+	assert.Equal(t, []string{"GB"}, senderCountries("+447400123456"))
+	assert.Equal(t, []string{"IM"}, senderCountries("+447624123456"))
+	assert.Equal(t, []string{"JE"}, senderCountries("+441534123456"))
+	assert.Equal(t, []string{"RU"}, senderCountries("+79161234567"))
+	assert.Equal(t, []string{"KZ"}, senderCountries("+77011234567"))
+	assert.Equal(t, []string{"AX"}, senderCountries("+358181234567"))
+
+	// unless they share those too
+	assert.Equal(t, []string{"MA", "EH"}, senderCountries("+212612345678"))
+
+	assert.Nil(t, senderCountries("+2591234"))
+	assert.Nil(t, senderCountries("+"))
+	assert.Nil(t, senderCountries("bob"))
+}
+
+func TestHandlerSharedReceive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+	}))
+	defer server.Close()
+
+	interchanges := strings.Replace(handlerConfig, "https://handler1", server.URL+"/handler1", -1)
+	interchanges = strings.Replace(interchanges, "https://handler2", server.URL+"/handler2", -1)
+	others := []struct {
+		uuid        string
+		country     string
+		scheme      string
+		channelUUID string
+		path        string
+	}{
+		{"afc2532c-1565-4016-a83e-fc6bc1ac3550", "rw", "tel", "7331140b-2be0-4855-92e1-fd06ca456364", "rwanda"},
+		{"e9bbba58-4ea7-4ec4-9b5c-23b1bd4c5f1a", "KE", "whatsapp", "f6c2b2d3-8b8d-4e2d-9d4e-5f3b0a3c8e11", "kenya"},
+		{"a43b0664-6b9e-4be5-a16d-2dec9c79506b", "GB", "tel", "7f25940a-d97a-45cb-abe1-204e93214a43", "uk"},
+		{"4797d1a5-c388-4b13-aa7e-b431cf831a1b", "US", "tel", "23f77a0f-5ec9-4fc4-b7fd-06fb9043e5c8", "us"},
+		{"09fff606-6c4a-4246-997d-7c5c0b0a7924", "KZ", "tel", "ee583186-5d26-4631-9b8a-d47d148b72aa", "kazakhstan"},
+		{"3febc8bb-6622-4bc4-9afb-bdf3dbaba87a", "NZ", "tel", "5d99c003-5687-4642-ba33-c414cd4df449", "new-zealand"},
+		{"b6f8d8ef-c4c6-4e63-b0c7-cb311b4bfeb5", "MA", "tel", "28dbd416-ba02-4ecf-9b63-ceb6c1f2c7c4", "morocco"},
+		{"e34fa1f6-ac7f-4104-9bb6-c106c16554a0", "EH", "tel", "2be627fd-a545-4d7e-ad6b-ee8ab5d4d6ea", "western-sahara"},
+	}
+	interchanges = strings.TrimSuffix(interchanges, "]")
+	for _, o := range others {
+		interchanges += fmt.Sprintf(`,{"uuid": "%s", "name": "%s", "country": "%s", "scheme": "%s", "channels": [{"uuid": "%s", "name": "%s", "url": "%s/%s"}]}`,
+			o.uuid, o.path, o.country, o.scheme, o.channelUUID, o.path, server.URL, o.path)
+	}
+	interchanges += "]"
+
+	start := func(fallback string) *Server {
+		config := NewConfig()
+		config.DB = "memory:"
+		config.FallbackInterchange = fallback
+		s := NewServer(config, http.Dir("static"))
+		if err := s.Start(); err != nil {
+			t.Fatalf("error starting server: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{interchanges}}, true, 200, "configuration saved"))
+		return s
+	}
+
+	// senders go to the interchange for their country and our scheme
+	s := start("")
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=250788000001", http.MethodGet, nil, false, 200, "rwanda"))
+	assert.NoError(t, makeTestRequest("/receive", http.MethodPost, url.Values{"sender": []string{"+22790000001"}, "message": []string{"two"}}, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=22790000001", http.MethodGet, nil, false, 200, "handler2"))
+	assert.NoError(t, makeTestRequest("/s/whatsapp/receive?message=test&sender=254700000001", http.MethodGet, nil, false, 200, "kenya"))
+	assert.NoError(t, makeTestRequest("/receive?message=test", http.MethodGet, nil, false, 400, "missing sender field"))
+	assert.NoError(t, makeTestRequest("/receive", http.MethodPost, url.Values{"sender": []string{"+250788000001"}, "message": []string{strings.Repeat("o", maxRequestBody)}}, false, 413, "request body too large"))
+
+	// countries which share a calling code are found by their number ranges, or if those are shared too, by which of
+	// the countries has an interchange
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=447400123456", http.MethodGet, nil, false, 200, "uk"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=12065551212", http.MethodGet, nil, false, 200, "us"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=77011234567", http.MethodGet, nil, false, 200, "kazakhstan"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=6421123456", http.MethodGet, nil, false, 200, "new-zealand"))
+
+	// without a fallback interchange, senders we can't resolve are rejected
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=254700000001", http.MethodGet, nil, false, 404, "interchange not found"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=14165551212", http.MethodGet, nil, false, 404, "interchange not found"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=79161234567", http.MethodGet, nil, false, 404, "interchange not found"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=441534123456", http.MethodGet, nil, false, 404, "interchange not found"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=212612345678", http.MethodGet, nil, false, 404, "interchange not found"))
+
+	// changing the country of an interchange reroutes senders straight away
+	moved := strings.Replace(interchanges, `"country": "GB"`, `"country": "JE"`, 1)
+	assert.NoError(t, makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{moved}}, true, 200, "configuration saved"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=441534123456", http.MethodGet, nil, false, 200, "uk"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=447400123456", http.MethodGet, nil, false, 404, "interchange not found"))
+	s.Stop()
+
+	// with one they go there instead
+	s = start("afc2532c-1565-4016-a83e-fc6bc1ac3550")
+	defer s.Stop()
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=212612345678", http.MethodGet, nil, false, 200, "rwanda"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=2591234", http.MethodGet, nil, false, 200, "rwanda"))
+	assert.NoError(t, makeTestRequest("/receive?message=test&sender=254700000001", http.MethodGet, nil, false, 200, "rwanda"))
+
+	err := makeTestRequest("/admin/stats", http.MethodGet, nil, true, 200, `"receive_fallbacks":{"ambiguous_country":1,"no_interchange":1,"unknown_country":1}`)
+	assert.NoError(t, err)
+}
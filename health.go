@@ -0,0 +1,220 @@
+package clover
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// how often we prune old health check results and how long we keep them
+var (
+	healthPruneInterval = time.Hour
+	healthRetention     = 7 * 24 * time.Hour
+)
+
+// channelHealth is the current health of a channel we check, as of its last check
+type channelHealth struct {
+	UUID      string                    `json:"uuid"`
+	Name      string                    `json:"name"`
+	Healthy   bool                      `json:"healthy"`
+	Since     time.Time                 `json:"since"`
+	LastCheck *models.HealthCheckResult `json:"last_check"`
+
+	// how many times the health of this channel has changed, filled in from our counts when reported
+	Changes int64 `json:"changes"`
+}
+
+// healthStates is the health of each channel we check, from our own probes or the results recorded by the instance
+// probing them, channels we don't check or haven't checked yet are considered healthy
+type healthStates struct {
+	mutex    sync.Mutex
+	channels map[string]*channelHealth
+}
+
+func newHealthStates() *healthStates {
+	return &healthStates{channels: make(map[string]*channelHealth)}
+}
+
+// healthy returns whether the channel with the passed in UUID is healthy
+func (h *healthStates) healthy(uuid string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	state := h.channels[uuid]
+	return state == nil || state.Healthy
+}
+
+// update records the result of checking the passed in channel, returning whether its health changed. Results no
+// newer than the last one we recorded are ignored.
+func (h *healthStates) update(channel *models.Channel, result *models.HealthCheckResult) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	state := h.channels[channel.UUID]
+	if state != nil && state.LastCheck != nil && !result.CheckedOn.After(state.LastCheck.CheckedOn) {
+		return false
+	}
+	if state == nil {
+		state = &channelHealth{UUID: channel.UUID, Healthy: true, Since: result.CheckedOn}
+		h.channels[channel.UUID] = state
+	}
+
+	changed := state.Healthy != result.Healthy
+	if changed {
+		state.Healthy = result.Healthy
+		state.Since = result.CheckedOn
+	}
+	state.Name = channel.Name
+	state.LastCheck = result
+	return changed
+}
+
+// retain forgets the health of any channels we no longer check
+func (h *healthStates) retain(checked map[string]bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for uuid := range h.channels {
+		if !checked[uuid] {
+			delete(h.channels, uuid)
+		}
+	}
+}
+
+// snapshot returns the health of every channel we check, ordered by channel UUID
+func (h *healthStates) snapshot() []channelHealth {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	states := make([]channelHealth, 0, len(h.channels))
+	for _, state := range h.channels {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].UUID < states[j].UUID })
+	return states
+}
+
+// unhealthy returns the UUIDs of the channels which are currently unhealthy
+func (h *healthStates) unhealthy() []string {
+	uuids := make([]string, 0)
+	for _, state := range h.snapshot() {
+		if !state.Healthy {
+			uuids = append(uuids, state.UUID)
+		}
+	}
+	return uuids
+}
+
+// checkHealth probes every channel which configures a health check, recording the results and noting any changes
+// in health. Only one instance probes our channels each round, the others read the results it records.
+func (s *Server) checkHealth(ctx context.Context) error {
+	interchanges, err := s.store.GetInterchangeConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	// we leave this round to another instance if it is probing or has probed in the last half interval
+	interval := time.Duration(s.config.HealthCheckInterval) * time.Second
+	release, probe, err := s.store.ClaimHealthChecks(ctx, time.Now().Add(-interval/2))
+	if err != nil {
+		return err
+	}
+	if probe {
+		defer release()
+	}
+
+	checked := make(map[string]bool)
+	wg := sync.WaitGroup{}
+	for _, interchange := range interchanges {
+		for c := range interchange.Channels {
+			channel := &interchange.Channels[c]
+			if channel.HealthCheck == nil {
+				continue
+			}
+			checked[channel.UUID] = true
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				var result *models.HealthCheckResult
+				if probe {
+					result = s.probeChannel(ctx, channel)
+
+					// if we are stopping, our probe was cut short and says nothing about our channel
+					if ctx.Err() != nil {
+						return
+					}
+
+					if err := s.store.InsertHealthCheck(ctx, result); err != nil {
+						slog.Error("error recording health check", "error", err, "channel_uuid", channel.UUID)
+					}
+				} else {
+					results, err := s.store.GetHealthChecks(ctx, channel.UUID, 1)
+					if err != nil || len(results) == 0 {
+						return
+					}
+					result = results[0]
+				}
+
+				if s.health.update(channel, result) {
+					s.healthChanges.inc(channel.UUID)
+					if result.Healthy {
+						slog.Info("channel became healthy", "interchange_uuid", interchange.UUID, "channel_uuid", channel.UUID)
+					} else {
+						slog.Warn("channel became unhealthy", "interchange_uuid", interchange.UUID, "channel_uuid", channel.UUID, "status_code", result.StatusCode, "error", result.Error)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	s.health.retain(checked)
+	return nil
+}
+
+// probeChannel makes the health check request configured for the passed in channel, within the channel's timeouts
+// and with any authentication it requires
+func (s *Server) probeChannel(ctx context.Context, channel *models.Channel) *models.HealthCheckResult {
+	start := time.Now()
+	result := &models.HealthCheckResult{ChannelUUID: channel.UUID, CheckedOn: start}
+
+	ctx, cancel := context.WithTimeout(ctx, channel.ResponseTimeout())
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, channel.HealthCheckMethod(), channel.HealthCheckURL(), nil)
+	if err == nil {
+		authenticateOutbound(request, channel.Auth, nil, start)
+
+		var resp *http.Response
+		resp, err = s.clients.forChannel(channel).Do(request)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			result.StatusCode = resp.StatusCode
+		}
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	} else if result.StatusCode != channel.HealthCheckStatus() {
+		result.Error = fmt.Sprintf("expected status %d", channel.HealthCheckStatus())
+	}
+
+	result.Healthy = result.Error == ""
+	result.ElapsedMS = int(time.Since(start).Milliseconds())
+	return result
+}
+
+// pruneHealthChecks removes the health check results older than we keep them
+func (s *Server) pruneHealthChecks(ctx context.Context) error {
+	return s.store.PruneHealthChecks(ctx, time.Now().Add(-healthRetention))
+}
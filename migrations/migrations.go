@@ -2,8 +2,11 @@ package migrations
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,6 +17,15 @@ type migration struct {
 	version     int
 	description string
 	sql         string
+
+	// the SQL which undoes this migration
+	down string
+}
+
+// checksum returns the checksum of our SQL, whitespace is normalized so reformatting a migration doesn't change it
+func (m *migration) checksum() string {
+	hash := sha256.Sum256([]byte(strings.Join(strings.Fields(m.sql), " ")))
+	return hex.EncodeToString(hash[:])
 }
 
 var (
@@ -37,6 +49,7 @@ var (
 				url TEXT NOT NULL,
 				keywords TEXT[] NULL 
 			)`,
+			down: `DROP TABLE channels`,
 		},
 		{
 			version:     3,
@@ -49,6 +62,7 @@ var (
 				scheme VARCHAR(32) NOT NULL,
 				default_channel_uuid UUID REFERENCES channels(uuid) INITIALLY DEFERRED NOT NULL
 			)`,
+			down: `DROP TABLE interchanges`,
 		},
 		{
 			version:     4,
@@ -56,6 +70,7 @@ var (
 			sql: `
 			ALTER TABLE channels ADD COLUMN interchange_uuid UUID REFERENCES interchanges(uuid) ON DELETE CASCADE INITIALLY DEFERRED NOT NULL
 			`,
+			down: `ALTER TABLE channels DROP COLUMN interchange_uuid`,
 		},
 		{
 			version:     5,
@@ -66,6 +81,7 @@ var (
 				interchange_uuid UUID REFERENCES interchanges(uuid) ON DELETE CASCADE NOT NULL,
 				channel_uuid UUID REFERENCES channels(uuid) ON DELETE CASCADE NOT NULL
 			)`,
+			down: `DROP TABLE urn_mappings`,
 		},
 		{
 			version:     6,
@@ -75,39 +91,300 @@ var (
 				urn, 
 				interchange_uuid
 			)`,
+			down: `DROP INDEX urn_mappings_idx`,
+		},
+		{
+			version:     7,
+			description: "add created_on and modified_on to urn_mappings",
+			sql: `
+			ALTER TABLE urn_mappings 
+				ADD COLUMN created_on TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+				ADD COLUMN modified_on TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+			`,
+			down: `
+			ALTER TABLE urn_mappings 
+				DROP COLUMN created_on,
+				DROP COLUMN modified_on
+			`,
+		},
+		{
+			version:     8,
+			description: "install mappings by channel index",
+			sql: `
+			CREATE INDEX urn_mappings_channel_idx ON urn_mappings(
+				interchange_uuid,
+				channel_uuid,
+				urn
+			)`,
+			down: `DROP INDEX urn_mappings_channel_idx`,
+		},
+		{
+			version:     9,
+			description: "install users table",
+			sql: `
+			CREATE TABLE users (
+				username VARCHAR(64) NOT NULL PRIMARY KEY,
+				password_hash TEXT NOT NULL,
+				role VARCHAR(16) NOT NULL,
+				created_on TIMESTAMP WITH TIME ZONE NOT NULL,
+				modified_on TIMESTAMP WITH TIME ZONE NOT NULL
+			)`,
+			down: `DROP TABLE users`,
+		},
+		{
+			version:     10,
+			description: "install api_tokens table",
+			sql: `
+			CREATE TABLE api_tokens (
+				id SERIAL PRIMARY KEY,
+				name TEXT NOT NULL,
+				token_hash VARCHAR(64) NOT NULL UNIQUE,
+				permissions TEXT[] NOT NULL,
+				interchange_uuids TEXT[] NOT NULL,
+				created_by VARCHAR(64) NOT NULL,
+				created_on TIMESTAMP WITH TIME ZONE NOT NULL,
+				last_used_on TIMESTAMP WITH TIME ZONE NULL,
+				revoked_on TIMESTAMP WITH TIME ZONE NULL
+			)`,
+			down: `DROP TABLE api_tokens`,
+		},
+		{
+			version:     11,
+			description: "install audit_log table",
+			sql: `
+			CREATE TABLE audit_log (
+				id BIGSERIAL PRIMARY KEY,
+				created_on TIMESTAMP WITH TIME ZONE NOT NULL,
+				actor VARCHAR(64) NOT NULL,
+				action VARCHAR(32) NOT NULL,
+				target TEXT NOT NULL,
+				before JSONB NULL,
+				after JSONB NULL,
+				source_ip VARCHAR(64) NOT NULL,
+				request_id VARCHAR(128) NOT NULL
+			);
+			CREATE INDEX audit_log_created_on_idx ON audit_log(created_on);
+			CREATE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+			CREATE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
+			`,
+			down: `DROP TABLE audit_log`,
+		},
+		{
+			version:     12,
+			description: "add checksum to migrations table",
+			sql:         `ALTER TABLE migrations ADD COLUMN checksum VARCHAR(64) NULL`,
+			down:        `ALTER TABLE migrations DROP COLUMN checksum`,
+		},
+		{
+			version:     13,
+			description: "add outbound auth to channels",
+			sql:         `ALTER TABLE channels ADD COLUMN auth JSONB NULL`,
+			down:        `ALTER TABLE channels DROP COLUMN auth`,
+		},
+		{
+			version:     14,
+			description: "add inbound verification to interchanges",
+			sql:         `ALTER TABLE interchanges ADD COLUMN auth JSONB NULL`,
+			down:        `ALTER TABLE interchanges DROP COLUMN auth`,
+		},
+		{
+			version:     15,
+			description: "add allowed IPs to interchanges",
+			sql:         `ALTER TABLE interchanges ADD COLUMN allowed_ips TEXT[] NULL`,
+			down:        `ALTER TABLE interchanges DROP COLUMN allowed_ips`,
+		},
+		{
+			version:     16,
+			description: "add rate limits",
+			sql: `
+			ALTER TABLE interchanges ADD COLUMN rate_limit JSONB NULL;
+			CREATE TABLE rate_limits (
+				key TEXT NOT NULL PRIMARY KEY,
+				tokens DOUBLE PRECISION NOT NULL,
+				allowed BOOLEAN NOT NULL,
+				updated_on TIMESTAMP WITH TIME ZONE NOT NULL,
+				full_on TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE INDEX rate_limits_full_on_idx ON rate_limits(full_on);
+			`,
+			down: `
+			DROP TABLE rate_limits;
+			ALTER TABLE interchanges DROP COLUMN rate_limit;
+			`,
+		},
+		{
+			version:     17,
+			description: "add duplicate suppression",
+			sql: `
+			ALTER TABLE interchanges ADD COLUMN dedup JSONB NULL;
+			CREATE TABLE dedup_keys (
+				interchange_uuid UUID REFERENCES interchanges(uuid) ON DELETE CASCADE NOT NULL,
+				key TEXT NOT NULL,
+				status_code INT NOT NULL,
+				body BYTEA NULL,
+				expires_on TIMESTAMP WITH TIME ZONE NOT NULL,
+				PRIMARY KEY (interchange_uuid, key)
+			);
+			CREATE INDEX dedup_keys_expires_on_idx ON dedup_keys(expires_on);
+			`,
+			down: `
+			DROP TABLE dedup_keys;
+			ALTER TABLE interchanges DROP COLUMN dedup;
+			`,
+		},
+		{
+			version:     18,
+			description: "add forwarding settings to channels",
+			sql:         `ALTER TABLE channels ADD COLUMN forwarding JSONB NULL`,
+			down:        `ALTER TABLE channels DROP COLUMN forwarding`,
+		},
+		{
+			version:     19,
+			description: "add channel health checks",
+			sql: `
+			ALTER TABLE channels ADD COLUMN health_check JSONB NULL;
+			CREATE TABLE health_checks (
+				id SERIAL PRIMARY KEY,
+				channel_uuid UUID REFERENCES channels(uuid) ON DELETE CASCADE NOT NULL,
+				healthy BOOLEAN NOT NULL,
+				status_code INT NOT NULL,
+				error TEXT NOT NULL,
+				elapsed_ms INT NOT NULL,
+				checked_on TIMESTAMP WITH TIME ZONE NOT NULL
+			);
+			CREATE INDEX health_checks_channel_uuid_idx ON health_checks(channel_uuid, id);
+			CREATE INDEX health_checks_checked_on_idx ON health_checks(checked_on);
+			`,
+			down: `
+			DROP TABLE health_checks;
+			ALTER TABLE channels DROP COLUMN health_check;
+			`,
+		},
+		{
+			version:     20,
+			description: "add distribution of unmapped senders",
+			sql: `
+			ALTER TABLE interchanges ADD COLUMN distribution VARCHAR(16) NOT NULL DEFAULT '';
+			ALTER TABLE channels ADD COLUMN weight INT NOT NULL DEFAULT 0;
+			ALTER TABLE channels ADD COLUMN capacity INT NOT NULL DEFAULT 0;
+			`,
+			down: `
+			ALTER TABLE channels DROP COLUMN capacity;
+			ALTER TABLE channels DROP COLUMN weight;
+			ALTER TABLE interchanges DROP COLUMN distribution;
+			`,
+		},
+		{
+			version:     21,
+			description: "add what happens to senders when channels are full",
+			sql:         `ALTER TABLE channels ADD COLUMN when_full JSONB NULL`,
+			down:        `ALTER TABLE channels DROP COLUMN when_full`,
+		},
+		{
+			version:     22,
+			description: "add schedules to interchanges",
+			sql: `
+			ALTER TABLE interchanges ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '';
+			ALTER TABLE interchanges ADD COLUMN schedules JSONB NULL;
+			`,
+			down: `
+			ALTER TABLE interchanges DROP COLUMN schedules;
+			ALTER TABLE interchanges DROP COLUMN timezone;
+			`,
+		},
+		{
+			version:     23,
+			description: "add prefix rules to interchanges",
+			sql:         `ALTER TABLE interchanges ADD COLUMN prefix_rules JSONB NULL`,
+			down:        `ALTER TABLE interchanges DROP COLUMN prefix_rules`,
 		},
 	}
 )
 
-const (
-	latestMigrationSQL = `
-	SELECT MAX(version) FROM migrations
-	`
+// dialect is the set of migrations for a kind of database and how to apply them
+type dialect struct {
+	migrations []migration
 
-	insertMigration = `
-	INSERT INTO migrations(version, applied_on) 
-	VALUES($1, NOW())
-	`
+	// the version of the migration which adds checksums to our migrations table
+	checksumVersion int
+
+	// whether we should take an advisory lock while migrating
+	advisoryLock bool
+}
+
+var (
+	postgresDialect = &dialect{migrations: migrations, checksumVersion: 12, advisoryLock: true}
+	sqliteDialect   = &dialect{migrations: sqliteMigrations, checksumVersion: 6}
 )
 
-func getVersion(ctx context.Context, db *sqlx.DB) (int, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
+// dialectFor returns the dialect for the passed in DB
+func dialectFor(db *sqlx.DB) *dialect {
+	if db.DriverName() == "sqlite" {
+		return sqliteDialect
+	}
+	return postgresDialect
+}
 
-	// get our latest version,
-	version := 0
-	err := db.GetContext(ctx, &version, latestMigrationSQL)
+// the key of the advisory lock we hold while migrating, so instances starting at the same time don't race
+const migrationLockKey = 7273420
 
-	// is our table missing? if so, we are version 0
-	if err != nil && strings.Contains(err.Error(), "does not exist") {
-		return 0, nil
+// appliedMigration is the record of a migration having been applied, checksum is nil for migrations applied before
+// we recorded checksums
+type appliedMigration struct {
+	Version   int       `db:"version"`
+	AppliedOn time.Time `db:"applied_on"`
+	Checksum  *string   `db:"checksum"`
+}
+
+// getApplied returns the migrations which have been applied to the passed in DB by version
+func getApplied(ctx context.Context, db *sqlx.DB) (map[int]*appliedMigration, error) {
+	rows := make([]*appliedMigration, 0, 20)
+	err := db.SelectContext(ctx, &rows, `SELECT * FROM migrations ORDER BY version`)
+
+	// is our table missing? if so, nothing has been applied
+	if err != nil && (strings.Contains(err.Error(), "does not exist") || strings.Contains(err.Error(), "no such table")) {
+		return map[int]*appliedMigration{}, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// table exists, query current version
-	return version, err
+	applied := make(map[int]*appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
 }
 
-func apply(ctx context.Context, db *sqlx.DB, mig migration) error {
+// lock takes our advisory lock if our dialect uses one, returning a function to release it. The lock is held by a
+// session so we hold on to a connection until we unlock.
+func (d *dialect) lock(ctx context.Context, db *sqlx.DB) (func(), error) {
+	if !d.advisoryLock {
+		return func() {}, nil
+	}
+
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+		if err != nil {
+			slog.Error("error releasing migration lock", "error", err)
+		}
+		conn.Close()
+	}, nil
+}
+
+// apply applies the passed in migration and records it in a single transaction
+func (d *dialect) apply(ctx context.Context, db *sqlx.DB, mig *migration) (err error) {
 	log := slog.With(
 		"version", mig.version,
 		"description", mig.description,
@@ -115,13 +392,30 @@ func apply(ctx context.Context, db *sqlx.DB, mig migration) error {
 
 	log.Info("applying migration")
 
-	_, err := db.ExecContext(ctx, mig.sql)
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	_, err = tx.ExecContext(ctx, mig.sql)
 	if err != nil {
 		log.Error("error applying migration", "error", err)
 		return err
 	}
 
-	_, err = db.ExecContext(ctx, insertMigration, mig.version)
+	// migrations before our checksum column exists are recorded without one and get their checksum once it does
+	if mig.version < d.checksumVersion {
+		_, err = tx.ExecContext(ctx, tx.Rebind(`INSERT INTO migrations(version, applied_on) VALUES(?, ?)`), mig.version, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, tx.Rebind(`INSERT INTO migrations(version, applied_on, checksum) VALUES(?, ?, ?)`), mig.version, time.Now().UTC(), mig.checksum())
+	}
 	if err != nil {
 		log.Error("error inserting migration record", "error", err)
 		return err
@@ -130,22 +424,155 @@ func apply(ctx context.Context, db *sqlx.DB, mig migration) error {
 	return nil
 }
 
-// Migrate installs any missing migrations for the passed in DB
+// revert undoes the passed in migration and removes its record in a single transaction
+func (d *dialect) revert(ctx context.Context, db *sqlx.DB, mig *migration) (err error) {
+	log := slog.With(
+		"version", mig.version,
+		"description", mig.description,
+	)
+
+	if mig.down == "" {
+		return fmt.Errorf("migration %d can't be reverted", mig.version)
+	}
+
+	log.Info("reverting migration")
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	_, err = tx.ExecContext(ctx, mig.down)
+	if err != nil {
+		log.Error("error reverting migration", "error", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`DELETE FROM migrations WHERE version = ?`), mig.version)
+	if err != nil {
+		log.Error("error deleting migration record", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// verify checks that none of the applied migrations have been edited since they were applied, migrations we don't
+// know about are logged but allowed so that older versions can still run
+func (d *dialect) verify(applied map[int]*appliedMigration) error {
+	known := make(map[int]bool, len(d.migrations))
+	for i := range d.migrations {
+		mig := &d.migrations[i]
+		known[mig.version] = true
+
+		record := applied[mig.version]
+		if record != nil && record.Checksum != nil && *record.Checksum != mig.checksum() {
+			return fmt.Errorf("migration %d (%s) has been edited since it was applied", mig.version, mig.description)
+		}
+	}
+
+	for version := range applied {
+		if !known[version] {
+			slog.Error(fmt.Sprintf("db has migration: %d which is newer than our latest migration: %d", version, d.migrations[len(d.migrations)-1].version))
+		}
+	}
+
+	return nil
+}
+
+// backfillChecksums records the checksums of any migrations applied before we recorded checksums
+func (d *dialect) backfillChecksums(ctx context.Context, db *sqlx.DB) error {
+	for i := range d.migrations {
+		mig := &d.migrations[i]
+		_, err := db.ExecContext(ctx, db.Rebind(`UPDATE migrations SET checksum = ? WHERE version = ? AND checksum IS NULL`), mig.checksum(), mig.version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrate applies any pending migrations to the passed in DB, which can be either postgres or sqlite. Each migration
+// is applied in its own transaction and on postgres an advisory lock is held for the whole run so that instances
+// starting at the same time don't race. An error is returned if an applied migration has since been edited.
 func Migrate(ctx context.Context, db *sqlx.DB) error {
-	version, err := getVersion(ctx, db)
+	d := dialectFor(db)
+
+	unlock, err := d.lock(ctx, db)
+	if err != nil {
+		slog.Error("unable to take migration lock", "error", err)
+		return err
+	}
+	defer unlock()
+
+	applied, err := getApplied(ctx, db)
 	if err != nil {
 		slog.Error("unable to get current db migration state", "error", err)
 		return err
 	}
 
-	slog.Info("database at migration", "version", version)
+	err = d.verify(applied)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("database at migration", "applied", len(applied), "latest", d.migrations[len(d.migrations)-1].version)
+
+	for i := range d.migrations {
+		mig := &d.migrations[i]
+		if applied[mig.version] != nil {
+			continue
+		}
+
+		err := d.apply(ctx, db, mig)
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.backfillChecksums(ctx, db)
+}
+
+// MigrateDown reverts all the applied migrations newer than the passed in version, newest first. The migrations
+// table itself is never reverted so version must be at least 1.
+func MigrateDown(ctx context.Context, db *sqlx.DB, version int) error {
+	if version < 1 {
+		return fmt.Errorf("can't migrate down to version %d, the minimum is 1", version)
+	}
+
+	d := dialectFor(db)
 
-	if version > migrations[len(migrations)-1].version {
-		slog.Error(fmt.Sprintf("db version: %d is greater than max migration: %d", version, migrations[len(migrations)-1].version))
+	unlock, err := d.lock(ctx, db)
+	if err != nil {
+		slog.Error("unable to take migration lock", "error", err)
+		return err
+	}
+	defer unlock()
+
+	applied, err := getApplied(ctx, db)
+	if err != nil {
+		return err
 	}
 
-	for _, mig := range migrations[version:] {
-		err := apply(ctx, db, mig)
+	err = d.verify(applied)
+	if err != nil {
+		return err
+	}
+
+	for i := len(d.migrations) - 1; i >= 0; i-- {
+		mig := &d.migrations[i]
+		if mig.version <= version || applied[mig.version] == nil {
+			continue
+		}
+
+		err := d.revert(ctx, db, mig)
 		if err != nil {
 			return err
 		}
@@ -153,3 +580,47 @@ func Migrate(ctx context.Context, db *sqlx.DB) error {
 
 	return nil
 }
+
+// Status is the state of a single migration
+type Status struct {
+	Version     int
+	Description string
+
+	// when this migration was applied, nil if it is pending
+	AppliedOn *time.Time
+
+	// whether this migration has been edited since it was applied
+	Modified bool
+}
+
+// GetStatus returns the status of every migration for the passed in DB in version order
+func GetStatus(ctx context.Context, db *sqlx.DB) ([]*Status, error) {
+	d := dialectFor(db)
+
+	applied, err := getApplied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*Status, 0, len(d.migrations))
+	for i := range d.migrations {
+		mig := &d.migrations[i]
+		status := &Status{Version: mig.version, Description: mig.description}
+
+		if record := applied[mig.version]; record != nil {
+			status.AppliedOn = &record.AppliedOn
+			status.Modified = record.Checksum != nil && *record.Checksum != mig.checksum()
+			delete(applied, mig.version)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	// include any migrations we don't know about
+	for version, record := range applied {
+		statuses = append(statuses, &Status{Version: version, Description: "unknown migration", AppliedOn: &record.AppliedOn})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	_ "modernc.org/sqlite"
+)
+
+func setUp(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite", "file:"+t.TempDir()+"/clover.db?_pragma=foreign_keys(1)&_time_format=sqlite")
+	if err != nil {
+		t.Fatalf("error opening db: %s", err)
+	}
+	return db
+}
+
+func assertApplied(t *testing.T, db *sqlx.DB, expected []bool) {
+	statuses, err := GetStatus(context.Background(), db)
+	assert.NoError(t, err)
+
+	applied := make([]bool, len(statuses))
+	for i, status := range statuses {
+		applied[i] = status.AppliedOn != nil
+		assert.False(t, status.Modified, "migration %d unexpectedly modified", status.Version)
+	}
+	assert.Equal(t, expected, applied)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := setUp(t)
+	defer db.Close()
+
+	assertApplied(t, db, []bool{false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false})
+
+	err := Migrate(ctx, db)
+	assert.NoError(t, err)
+	assertApplied(t, db, []bool{true, true, true, true, true, true, true, true, true, true, true, true, true, true, true, true, true})
+
+	// running again is a no-op
+	err = Migrate(ctx, db)
+	assert.NoError(t, err)
+
+	// all our migrations have checksums, including those applied before our checksum column existed
+	var missing int
+	err = db.Get(&missing, `SELECT COUNT(*) FROM migrations WHERE checksum IS NULL`)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, missing)
+
+	// migrate down, our later tables should be gone
+	err = MigrateDown(ctx, db, 3)
+	assert.NoError(t, err)
+	assertApplied(t, db, []bool{true, true, true, false, false, false, false, false, false, false, false, false, false, false, false, false, false})
+
+	_, err = db.Exec(`SELECT * FROM api_tokens`)
+	assert.Error(t, err)
+
+	err = MigrateDown(ctx, db, 0)
+	assert.EqualError(t, err, "can't migrate down to version 0, the minimum is 1")
+
+	// and back up again
+	err = Migrate(ctx, db)
+	assert.NoError(t, err)
+	assertApplied(t, db, []bool{true, true, true, true, true, true, true, true, true, true, true, true, true, true, true, true, true})
+
+	// a failing migration is rolled back along with its record
+	sqliteMigrations = append(sqliteMigrations, migration{version: 18, description: "broken", sql: `CREATE TABLE foo (id INT); SELECT * FROM bar`})
+	sqliteDialect.migrations = sqliteMigrations
+	defer func() {
+		sqliteMigrations = sqliteMigrations[:17]
+		sqliteDialect.migrations = sqliteMigrations
+	}()
+
+	err = Migrate(ctx, db)
+	assert.Error(t, err)
+	assertApplied(t, db, []bool{true, true, true, true, true, true, true, true, true, true, true, true, true, true, true, true, true, false})
+
+	_, err = db.Exec(`SELECT * FROM foo`)
+	assert.Error(t, err)
+}
+
+func TestMigrateEdited(t *testing.T) {
+	ctx := context.Background()
+	db := setUp(t)
+	defer db.Close()
+
+	err := Migrate(ctx, db)
+	assert.NoError(t, err)
+
+	// reformatting a migration doesn't change its checksum
+	original := sqliteMigrations[2].sql
+	defer func() { sqliteMigrations[2].sql = original }()
+
+	sqliteMigrations[2].sql = "  " + original + "\n\n"
+	err = Migrate(ctx, db)
+	assert.NoError(t, err)
+
+	// but editing it does
+	sqliteMigrations[2].sql = original + ";CREATE INDEX users_role_idx ON users(role);"
+	err = Migrate(ctx, db)
+	assert.EqualError(t, err, "migration 3 (install users table) has been edited since it was applied")
+
+	statuses, err := GetStatus(ctx, db)
+	assert.NoError(t, err)
+	assert.True(t, statuses[2].Modified)
+	assert.False(t, statuses[3].Modified)
+}
@@ -0,0 +1,230 @@
+package migrations
+
+// our migrations for sqlite databases, these give the same schema as our postgres migrations. Arrays such as channel
+// keywords are stored as text in the postgres array format so they scan the same way, and timestamps are stored as
+// text in a format sqlite's date functions understand.
+var sqliteMigrations = []migration{
+	{
+		version:     1,
+		description: "install migrations table",
+		sql: `
+		CREATE TABLE migrations (
+			version INT UNIQUE NOT NULL,
+			applied_on TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		version:     2,
+		description: "install interchanges, channels and urn_mappings tables",
+		sql: `
+		CREATE TABLE interchanges (
+			uuid TEXT NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			country VARCHAR(2) NOT NULL,
+			scheme VARCHAR(32) NOT NULL,
+			default_channel_uuid TEXT NOT NULL REFERENCES channels(uuid) DEFERRABLE INITIALLY DEFERRED
+		);
+		CREATE TABLE channels (
+			uuid TEXT NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			interchange_uuid TEXT NOT NULL REFERENCES interchanges(uuid) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+			url TEXT NOT NULL,
+			keywords TEXT NULL
+		);
+		CREATE TABLE urn_mappings (
+			urn VARCHAR(1024) NOT NULL,
+			interchange_uuid TEXT NOT NULL REFERENCES interchanges(uuid) ON DELETE CASCADE,
+			channel_uuid TEXT NOT NULL REFERENCES channels(uuid) ON DELETE CASCADE,
+			created_on TIMESTAMP NOT NULL,
+			modified_on TIMESTAMP NOT NULL
+		);
+		CREATE UNIQUE INDEX urn_mappings_idx ON urn_mappings(urn, interchange_uuid);
+		CREATE INDEX urn_mappings_channel_idx ON urn_mappings(interchange_uuid, channel_uuid, urn);
+		`,
+		down: `
+		DROP TABLE urn_mappings;
+		DROP TABLE channels;
+		DROP TABLE interchanges;
+		`,
+	},
+	{
+		version:     3,
+		description: "install users table",
+		sql: `
+		CREATE TABLE users (
+			username VARCHAR(64) NOT NULL PRIMARY KEY,
+			password_hash TEXT NOT NULL,
+			role VARCHAR(16) NOT NULL,
+			created_on TIMESTAMP NOT NULL,
+			modified_on TIMESTAMP NOT NULL
+		)`,
+		down: `DROP TABLE users`,
+	},
+	{
+		version:     4,
+		description: "install api_tokens table",
+		sql: `
+		CREATE TABLE api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			permissions TEXT NOT NULL,
+			interchange_uuids TEXT NOT NULL,
+			created_by VARCHAR(64) NOT NULL,
+			created_on TIMESTAMP NOT NULL,
+			last_used_on TIMESTAMP NULL,
+			revoked_on TIMESTAMP NULL
+		)`,
+		down: `DROP TABLE api_tokens`,
+	},
+	{
+		version:     5,
+		description: "install audit_log table",
+		sql: `
+		CREATE TABLE audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_on TIMESTAMP NOT NULL,
+			actor VARCHAR(64) NOT NULL,
+			action VARCHAR(32) NOT NULL,
+			target TEXT NOT NULL,
+			before TEXT NULL,
+			after TEXT NULL,
+			source_ip VARCHAR(64) NOT NULL,
+			request_id VARCHAR(128) NOT NULL
+		);
+		CREATE INDEX audit_log_created_on_idx ON audit_log(created_on);
+		CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log BEGIN SELECT RAISE(IGNORE); END;
+		CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(IGNORE); END;
+		`,
+		down: `DROP TABLE audit_log`,
+	},
+	{
+		version:     6,
+		description: "add checksum to migrations table",
+		sql:         `ALTER TABLE migrations ADD COLUMN checksum VARCHAR(64) NULL`,
+		down:        `ALTER TABLE migrations DROP COLUMN checksum`,
+	},
+	{
+		version:     7,
+		description: "add outbound auth to channels",
+		sql:         `ALTER TABLE channels ADD COLUMN auth TEXT NULL`,
+		down:        `ALTER TABLE channels DROP COLUMN auth`,
+	},
+	{
+		version:     8,
+		description: "add inbound verification to interchanges",
+		sql:         `ALTER TABLE interchanges ADD COLUMN auth TEXT NULL`,
+		down:        `ALTER TABLE interchanges DROP COLUMN auth`,
+	},
+	{
+		version:     9,
+		description: "add allowed IPs to interchanges",
+		sql:         `ALTER TABLE interchanges ADD COLUMN allowed_ips TEXT NULL`,
+		down:        `ALTER TABLE interchanges DROP COLUMN allowed_ips`,
+	},
+	{
+		version:     10,
+		description: "add rate limits",
+		sql: `
+		ALTER TABLE interchanges ADD COLUMN rate_limit TEXT NULL;
+		CREATE TABLE rate_limits (
+			key TEXT NOT NULL PRIMARY KEY,
+			tokens REAL NOT NULL,
+			allowed BOOLEAN NOT NULL,
+			updated_on REAL NOT NULL,
+			full_on REAL NOT NULL
+		);
+		CREATE INDEX rate_limits_full_on_idx ON rate_limits(full_on);
+		`,
+		down: `
+		DROP TABLE rate_limits;
+		ALTER TABLE interchanges DROP COLUMN rate_limit;
+		`,
+	},
+	{
+		version:     11,
+		description: "add duplicate suppression",
+		sql: `
+		ALTER TABLE interchanges ADD COLUMN dedup TEXT NULL;
+		CREATE TABLE dedup_keys (
+			interchange_uuid TEXT NOT NULL REFERENCES interchanges(uuid) ON DELETE CASCADE,
+			key TEXT NOT NULL,
+			status_code INT NOT NULL,
+			body BLOB NULL,
+			expires_on REAL NOT NULL,
+			PRIMARY KEY (interchange_uuid, key)
+		);
+		CREATE INDEX dedup_keys_expires_on_idx ON dedup_keys(expires_on);
+		`,
+		down: `
+		DROP TABLE dedup_keys;
+		ALTER TABLE interchanges DROP COLUMN dedup;
+		`,
+	},
+	{
+		version:     12,
+		description: "add forwarding settings to channels",
+		sql:         `ALTER TABLE channels ADD COLUMN forwarding TEXT NULL`,
+		down:        `ALTER TABLE channels DROP COLUMN forwarding`,
+	},
+	{
+		version:     13,
+		description: "add channel health checks",
+		sql: `
+		ALTER TABLE channels ADD COLUMN health_check TEXT NULL;
+		CREATE TABLE health_checks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_uuid TEXT NOT NULL REFERENCES channels(uuid) ON DELETE CASCADE,
+			healthy BOOLEAN NOT NULL,
+			status_code INT NOT NULL,
+			error TEXT NOT NULL,
+			elapsed_ms INT NOT NULL,
+			checked_on TIMESTAMP NOT NULL
+		);
+		CREATE INDEX health_checks_channel_uuid_idx ON health_checks(channel_uuid, id);
+		CREATE INDEX health_checks_checked_on_idx ON health_checks(checked_on);
+		`,
+		down: `
+		DROP TABLE health_checks;
+		ALTER TABLE channels DROP COLUMN health_check;
+		`,
+	},
+	{
+		version:     14,
+		description: "add distribution of unmapped senders",
+		sql: `
+		ALTER TABLE interchanges ADD COLUMN distribution VARCHAR(16) NOT NULL DEFAULT '';
+		ALTER TABLE channels ADD COLUMN weight INT NOT NULL DEFAULT 0;
+		ALTER TABLE channels ADD COLUMN capacity INT NOT NULL DEFAULT 0;
+		`,
+		down: `
+		ALTER TABLE channels DROP COLUMN capacity;
+		ALTER TABLE channels DROP COLUMN weight;
+		ALTER TABLE interchanges DROP COLUMN distribution;
+		`,
+	},
+	{
+		version:     15,
+		description: "add what happens to senders when channels are full",
+		sql:         `ALTER TABLE channels ADD COLUMN when_full TEXT NULL`,
+		down:        `ALTER TABLE channels DROP COLUMN when_full`,
+	},
+	{
+		version:     16,
+		description: "add schedules to interchanges",
+		sql: `
+		ALTER TABLE interchanges ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
+		ALTER TABLE interchanges ADD COLUMN schedules TEXT NULL;
+		`,
+		down: `
+		ALTER TABLE interchanges DROP COLUMN schedules;
+		ALTER TABLE interchanges DROP COLUMN timezone;
+		`,
+	},
+	{
+		version:     17,
+		description: "add prefix rules to interchanges",
+		sql:         `ALTER TABLE interchanges ADD COLUMN prefix_rules TEXT NULL`,
+		down:        `ALTER TABLE interchanges DROP COLUMN prefix_rules`,
+	},
+}
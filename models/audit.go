@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// the actions we record in our audit log
+const (
+	AuditConfigUpdate = "config.update"
+	AuditMappingSet   = "mapping.set"
+	AuditMappingClear = "mapping.clear"
+	AuditUserSave     = "user.save"
+	AuditUserDelete   = "user.delete"
+	AuditTokenCreate  = "token.create"
+	AuditTokenRevoke  = "token.revoke"
+)
+
+// AuditEntry is a record of a change made through the admin API
+type AuditEntry struct {
+	ID        int64           `db:"id"         json:"id"`
+	CreatedOn time.Time       `db:"created_on" json:"created_on"`
+	Actor     string          `db:"actor"      json:"actor"`
+	Action    string          `db:"action"     json:"action"`
+	Target    string          `db:"target"     json:"target"`
+	Before    *types.JSONText `db:"before"     json:"before"`
+	After     *types.JSONText `db:"after"      json:"after"`
+	SourceIP  string          `db:"source_ip"  json:"source_ip"`
+	RequestID string          `db:"request_id" json:"request_id"`
+}
+
+// NewAuditEntry creates a new audit entry, before and after are marshalled to JSON, either may be nil
+func NewAuditEntry(actor string, action string, target string, before interface{}, after interface{}, sourceIP string, requestID string) (*AuditEntry, error) {
+	entry := &AuditEntry{Actor: actor, Action: action, Target: target, SourceIP: sourceIP, RequestID: requestID}
+
+	var err error
+	entry.Before, err = toNullJSON(before)
+	if err != nil {
+		return nil, err
+	}
+	entry.After, err = toNullJSON(after)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func toNullJSON(v interface{}) (*types.JSONText, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return (*types.JSONText)(&j), nil
+}
+
+const insertAuditEntrySQL = `
+INSERT INTO audit_log (created_on, actor, action, target, before, after, source_ip, request_id)
+VALUES (NOW(), :actor, :action, :target, :before, :after, :source_ip, :request_id)
+`
+
+// InsertAuditEntry appends the passed in entry to our audit log
+func InsertAuditEntry(ctx context.Context, db *sqlx.DB, entry *AuditEntry) error {
+	_, err := db.NamedExecContext(ctx, insertAuditEntrySQL, entry)
+	if err != nil {
+		slog.Error("error inserting audit entry", "error", err)
+	}
+	return err
+}
+
+// AuditQuery filters the entries returned from our audit log, empty fields don't filter
+type AuditQuery struct {
+	Actor  string
+	Action string
+
+	// only include entries whose target starts with this
+	Target string
+
+	Since time.Time
+	Until time.Time
+
+	// only include entries older than this id, used for paging
+	BeforeID int64
+
+	// the maximum number of entries to return, ignored for exports
+	Limit int
+}
+
+const selectAuditEntriesSQL = `
+SELECT id, created_on, actor, action, target, before, after, source_ip, request_id
+FROM audit_log
+WHERE
+  ($1 = '' OR actor = $1) AND
+  ($2 = '' OR action = $2) AND
+  ($3 = '' OR target LIKE $3) AND
+  ($4::timestamptz IS NULL OR created_on >= $4) AND
+  ($5::timestamptz IS NULL OR created_on < $5) AND
+  ($6 = 0 OR id < $6)
+ORDER BY id DESC
+`
+
+func (q *AuditQuery) args() []interface{} {
+	target := ""
+	if q.Target != "" {
+		target = likeEscaper.Replace(q.Target) + "%"
+	}
+
+	return []interface{}{q.Actor, q.Action, target, nullTime(q.Since), nullTime(q.Until), q.BeforeID}
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// GetAuditEntries returns the entries matching the passed in query, newest first
+func GetAuditEntries(ctx context.Context, db *sqlx.DB, query *AuditQuery) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0, query.Limit)
+	err := db.SelectContext(ctx, &entries, selectAuditEntriesSQL+"LIMIT $7", append(query.args(), query.Limit)...)
+	if err != nil {
+		slog.Error("error selecting audit entries", "error", err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ExportAuditEntries streams all the entries matching the passed in query, newest first, calling fn for each
+func ExportAuditEntries(ctx context.Context, db *sqlx.DB, query *AuditQuery, fn func(*AuditEntry) error) error {
+	return streamCursor(ctx, db, selectAuditEntriesSQL, query.args(), func(rows *sqlx.Rows) error {
+		entry := &AuditEntry{}
+		err := rows.StructScan(entry)
+		if err != nil {
+			return err
+		}
+		return fn(entry)
+	})
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// the types of outbound authentication a channel can use
+const (
+	ChannelAuthBasic  = "basic"
+	ChannelAuthBearer = "bearer"
+	ChannelAuthHMAC   = "hmac"
+)
+
+// the types of verification an interchange can require of incoming requests
+const (
+	InterchangeAuthHMAC  = "hmac"
+	InterchangeAuthToken = "token"
+)
+
+// DefaultSignatureHeader is the header HMAC signatures are put in if a channel or interchange doesn't specify one
+const DefaultSignatureHeader = "X-Clover-Signature"
+
+// DefaultTokenParam is the query parameter tokens are read from if an interchange doesn't specify one
+const DefaultTokenParam = "token"
+
+// RedactedSecret is what we show in place of secrets, submitting it back keeps the stored secret
+const RedactedSecret = "********"
+
+// ChannelAuth is the authentication we add to requests we forward to a channel
+type ChannelAuth struct {
+	Type     string `json:"type"               validate:"required,oneof=basic bearer hmac"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+	Header   string `json:"header,omitempty"`
+}
+
+// Value returns our auth as JSON for storing in the db
+func (a *ChannelAuth) Value() (driver.Value, error) {
+	return jsonColumn[ChannelAuth]{a, "channel auth"}.Value()
+}
+
+// Scan reads our auth from the JSON stored in the db
+func (a *ChannelAuth) Scan(value any) error {
+	return jsonColumn[ChannelAuth]{a, "channel auth"}.Scan(value)
+}
+
+// check makes sure we have the fields our type needs
+func (a *ChannelAuth) check() error {
+	switch a.Type {
+	case ChannelAuthBasic:
+		if a.Username == "" || a.Password == "" {
+			return fmt.Errorf("basic auth requires a username and password")
+		}
+	case ChannelAuthBearer:
+		if a.Token == "" {
+			return fmt.Errorf("bearer auth requires a token")
+		}
+	case ChannelAuthHMAC:
+		if a.Secret == "" {
+			return fmt.Errorf("hmac auth requires a secret")
+		}
+	}
+	return nil
+}
+
+// redacted returns a copy of our auth with its secrets replaced by our placeholder
+func (a *ChannelAuth) redacted() *ChannelAuth {
+	r := *a
+	for _, secret := range []*string{&r.Password, &r.Token, &r.Secret} {
+		if *secret != "" {
+			*secret = RedactedSecret
+		}
+	}
+	return &r
+}
+
+// restore replaces any placeholder secrets with those from the passed in previous auth
+func (a *ChannelAuth) restore(previous *ChannelAuth) {
+	if previous == nil || previous.Type != a.Type {
+		return
+	}
+	if a.Password == RedactedSecret {
+		a.Password = previous.Password
+	}
+	if a.Token == RedactedSecret {
+		a.Token = previous.Token
+	}
+	if a.Secret == RedactedSecret {
+		a.Secret = previous.Secret
+	}
+}
+
+// InterchangeAuth is the verification an interchange requires of the requests it receives
+type InterchangeAuth struct {
+	Type   string `json:"type"             validate:"required,oneof=hmac token"`
+	Secret string `json:"secret,omitempty"`
+	Header string `json:"header,omitempty"`
+	Token  string `json:"token,omitempty"`
+	Param  string `json:"param,omitempty"`
+}
+
+// Value returns our auth as JSON for storing in the db
+func (a *InterchangeAuth) Value() (driver.Value, error) {
+	return jsonColumn[InterchangeAuth]{a, "interchange auth"}.Value()
+}
+
+// Scan reads our auth from the JSON stored in the db
+func (a *InterchangeAuth) Scan(value any) error {
+	return jsonColumn[InterchangeAuth]{a, "interchange auth"}.Scan(value)
+}
+
+// check makes sure we have the fields our type needs
+func (a *InterchangeAuth) check() error {
+	switch a.Type {
+	case InterchangeAuthHMAC:
+		if a.Secret == "" {
+			return fmt.Errorf("hmac verification requires a secret")
+		}
+	case InterchangeAuthToken:
+		if a.Token == "" {
+			return fmt.Errorf("token verification requires a token")
+		}
+	}
+	return nil
+}
+
+// redacted returns a copy of our auth with its secrets replaced by our placeholder
+func (a *InterchangeAuth) redacted() *InterchangeAuth {
+	r := *a
+	for _, secret := range []*string{&r.Secret, &r.Token} {
+		if *secret != "" {
+			*secret = RedactedSecret
+		}
+	}
+	return &r
+}
+
+// restore replaces any placeholder secrets with those from the passed in previous auth
+func (a *InterchangeAuth) restore(previous *InterchangeAuth) {
+	if previous == nil || previous.Type != a.Type {
+		return
+	}
+	if a.Secret == RedactedSecret {
+		a.Secret = previous.Secret
+	}
+	if a.Token == RedactedSecret {
+		a.Token = previous.Token
+	}
+}
+
+// RedactInterchanges returns copies of the passed in interchanges with all interchange and channel secrets redacted, suitable for
+// showing to users or recording in our audit log
+func RedactInterchanges(interchanges []*Interchange) []*Interchange {
+	redacted := make([]*Interchange, len(interchanges))
+	for i, interchange := range interchanges {
+		r := *interchange
+		if r.Auth != nil {
+			r.Auth = r.Auth.redacted()
+		}
+		r.Channels = make([]Channel, len(interchange.Channels))
+		copy(r.Channels, interchange.Channels)
+		for c := range r.Channels {
+			if r.Channels[c].Auth != nil {
+				r.Channels[c].Auth = r.Channels[c].Auth.redacted()
+			}
+		}
+		redacted[i] = &r
+	}
+	return redacted
+}
+
+// RestoreRedactedSecrets fills in any redacted secrets in the passed in interchanges with the secrets of the same
+// interchange or channel in our previous config, so that a redacted config can be edited and saved without re-entering them.
+// Returns an error if a redacted secret has nothing to restore from.
+func RestoreRedactedSecrets(interchanges []*Interchange, previous []*Interchange) error {
+	previousAuth := make(map[string]*ChannelAuth)
+	previousInterchangeAuth := make(map[string]*InterchangeAuth)
+	for _, interchange := range previous {
+		previousInterchangeAuth[interchange.UUID] = interchange.Auth
+		for _, channel := range interchange.Channels {
+			previousAuth[channel.UUID] = channel.Auth
+		}
+	}
+
+	for _, interchange := range interchanges {
+		if interchange.Auth != nil {
+			interchange.Auth.restore(previousInterchangeAuth[strings.ToLower(interchange.UUID)])
+			if interchange.Auth.Secret == RedactedSecret || interchange.Auth.Token == RedactedSecret {
+				return fmt.Errorf("no stored secret for interchange %s, it must be entered in full", interchange.UUID)
+			}
+		}
+
+		for c := range interchange.Channels {
+			channel := &interchange.Channels[c]
+			if channel.Auth == nil {
+				continue
+			}
+
+			channel.Auth.restore(previousAuth[strings.ToLower(channel.UUID)])
+			if channel.Auth.Password == RedactedSecret || channel.Auth.Token == RedactedSecret || channel.Auth.Secret == RedactedSecret {
+				return fmt.Errorf("no stored secret for channel %s, it must be entered in full", channel.UUID)
+			}
+		}
+	}
+	return nil
+}
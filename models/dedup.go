@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Dedup is the duplicate suppression configured on an interchange. Messages are identified by the value of an
+// external message ID field if one is set, otherwise by their sender and text, and are remembered for window seconds.
+type Dedup struct {
+	Field  string `json:"field,omitempty"`
+	Window int    `json:"window"          validate:"gte=1"`
+}
+
+// Value returns our dedup config as JSON for storing in the db
+func (d *Dedup) Value() (driver.Value, error) {
+	return jsonColumn[Dedup]{d, "dedup"}.Value()
+}
+
+// Scan reads our dedup config from the JSON stored in the db
+func (d *Dedup) Scan(value any) error {
+	return jsonColumn[Dedup]{d, "dedup"}.Scan(value)
+}
+
+// DedupResponse is the downstream response to a message we have seen, a zero status code means the message is
+// still being forwarded
+type DedupResponse struct {
+	StatusCode int    `db:"status_code"`
+	Body       []byte `db:"body"`
+}
+
+// Deduplicator remembers the messages we have seen and how they were responded to
+type Deduplicator interface {
+	// ClaimDedupKey claims the passed in key for an interchange for the passed in window. If it was already claimed
+	// we return false and the response it got, which has a zero status code if it is still being forwarded.
+	ClaimDedupKey(ctx context.Context, interchangeUUID string, key string, window time.Duration) (bool, *DedupResponse, error)
+
+	// CompleteDedupKey records the response to the message with a key we claimed
+	CompleteDedupKey(ctx context.Context, interchangeUUID string, key string, response *DedupResponse) error
+
+	// ReleaseDedupKey releases a key we claimed but couldn't forward, so that the message can be retried
+	ReleaseDedupKey(ctx context.Context, interchangeUUID string, key string) error
+
+	// PruneDedupKeys removes any keys which have expired
+	PruneDedupKeys(ctx context.Context) error
+}
+
+// claims a key, or reclaims it if it has expired but not yet been pruned, only returning a row if we claimed it
+const claimDedupKeySQL = `
+INSERT INTO dedup_keys (interchange_uuid, key, status_code, body, expires_on)
+VALUES ($1, $2, 0, NULL, NOW() + $3::float8 * INTERVAL '1 second')
+ON CONFLICT (interchange_uuid, key)
+DO
+ UPDATE
+   SET status_code = 0, body = NULL, expires_on = EXCLUDED.expires_on
+ WHERE dedup_keys.expires_on < NOW()
+RETURNING key
+`
+
+// ClaimDedupKey claims the passed in key for an interchange, returning the response to its message if already claimed
+func ClaimDedupKey(ctx context.Context, db *sqlx.DB, interchangeUUID string, key string, window time.Duration) (bool, *DedupResponse, error) {
+	var claimed string
+	err := db.GetContext(ctx, &claimed, claimDedupKeySQL, interchangeUUID, key, window.Seconds())
+	if err == nil {
+		return true, nil, nil
+	}
+	if err != sql.ErrNoRows {
+		slog.Error("error claiming dedup key", "error", err)
+		return false, nil, err
+	}
+
+	response := &DedupResponse{}
+	err = db.GetContext(ctx, response, `SELECT status_code, body FROM dedup_keys WHERE interchange_uuid = $1 AND key = $2`, interchangeUUID, key)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, response, nil
+}
+
+// CompleteDedupKey records the response to the message with a key we claimed
+func CompleteDedupKey(ctx context.Context, db *sqlx.DB, interchangeUUID string, key string, response *DedupResponse) error {
+	_, err := db.ExecContext(ctx, `UPDATE dedup_keys SET status_code = $3, body = $4 WHERE interchange_uuid = $1 AND key = $2`, interchangeUUID, key, response.StatusCode, response.Body)
+	return err
+}
+
+// ReleaseDedupKey releases a key we claimed but couldn't forward
+func ReleaseDedupKey(ctx context.Context, db *sqlx.DB, interchangeUUID string, key string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM dedup_keys WHERE interchange_uuid = $1 AND key = $2`, interchangeUUID, key)
+	return err
+}
+
+// PruneDedupKeys removes any keys which have expired
+func PruneDedupKeys(ctx context.Context, db *sqlx.DB) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM dedup_keys WHERE expires_on < NOW()`)
+	return err
+}
+
+// memoryDeduplicator keeps our dedup keys in memory
+type memoryDeduplicator struct {
+	mutex sync.Mutex
+	keys  map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	response  DedupResponse
+	expiresOn time.Time
+}
+
+func newMemoryDeduplicator() *memoryDeduplicator {
+	return &memoryDeduplicator{keys: make(map[string]*dedupEntry)}
+}
+
+func (d *memoryDeduplicator) ClaimDedupKey(ctx context.Context, interchangeUUID string, key string, window time.Duration) (bool, *DedupResponse, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	entry, found := d.keys[interchangeUUID+":"+key]
+	if found && entry.expiresOn.After(time.Now()) {
+		response := entry.response
+		return false, &response, nil
+	}
+
+	d.keys[interchangeUUID+":"+key] = &dedupEntry{expiresOn: time.Now().Add(window)}
+	return true, nil, nil
+}
+
+func (d *memoryDeduplicator) CompleteDedupKey(ctx context.Context, interchangeUUID string, key string, response *DedupResponse) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if entry, found := d.keys[interchangeUUID+":"+key]; found {
+		entry.response = *response
+	}
+	return nil
+}
+
+func (d *memoryDeduplicator) ReleaseDedupKey(ctx context.Context, interchangeUUID string, key string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.keys, interchangeUUID+":"+key)
+	return nil
+}
+
+func (d *memoryDeduplicator) PruneDedupKeys(ctx context.Context) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	for key, entry := range d.keys {
+		if entry.expiresOn.Before(now) {
+			delete(d.keys, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// the number of rows we fetch from our cursors at a time
+const exportBatchSize = 1000
+
+const exportURNMappingsSQL = `
+SELECT urn, interchange_uuid, channel_uuid, created_on, modified_on
+FROM urn_mappings
+WHERE interchange_uuid = $1 AND ($2 = '' OR channel_uuid::text = $2)
+ORDER BY urn
+`
+
+// ExportURNMappings streams all the URN mappings for the passed in interchange, calling fn for each one. If channelUUID
+// is not empty, only mappings to that channel are exported. Mappings are read using a server side cursor so that large
+// interchanges are never loaded into memory at once.
+func ExportURNMappings(ctx context.Context, db *sqlx.DB, interchangeUUID string, channelUUID string, fn func(*URNMapping) error) error {
+	return streamCursor(ctx, db, exportURNMappingsSQL, []interface{}{interchangeUUID, channelUUID}, func(rows *sqlx.Rows) error {
+		mapping := &URNMapping{}
+		err := rows.StructScan(mapping)
+		if err != nil {
+			return err
+		}
+		return fn(mapping)
+	})
+}
+
+// streamCursor runs the passed in query using a server side cursor, calling fn for each row
+func streamCursor(ctx context.Context, db *sqlx.DB, query string, args []interface{}, fn func(*sqlx.Rows) error) error {
+	// cursors only live as long as their transaction
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	// we never write anything, so always roll back
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DECLARE export_cursor NO SCROLL CURSOR FOR "+query, args...)
+	if err != nil {
+		slog.Error("error declaring export cursor", "error", err)
+		return err
+	}
+
+	for {
+		fetched, err := fetchCursorBatch(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+
+		if fetched < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+var fetchCursorSQL = fmt.Sprintf(`FETCH FORWARD %d FROM export_cursor`, exportBatchSize)
+
+// fetchCursorBatch fetches the next batch of rows from our export cursor, returning how many were fetched
+func fetchCursorBatch(ctx context.Context, tx *sqlx.Tx, fn func(*sqlx.Rows) error) (int, error) {
+	rows, err := tx.QueryxContext(ctx, fetchCursorSQL)
+	if err != nil {
+		slog.Error("error fetching from export cursor", "error", err)
+		return 0, err
+	}
+	defer rows.Close()
+
+	fetched := 0
+	for rows.Next() {
+		err = fn(rows)
+		if err != nil {
+			return fetched, err
+		}
+		fetched++
+	}
+
+	return fetched, rows.Err()
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// the defaults for channels which don't configure their own forwarding
+const (
+	DefaultConnectTimeout  = 5
+	DefaultResponseTimeout = 20
+	DefaultBreakerFailures = 5
+	DefaultBreakerCooldown = 30
+)
+
+// Forwarding is how requests are forwarded to a channel. Timeouts and the breaker cooldown are in seconds, and
+// requests are given up on by our middleware after 30 seconds regardless. The breaker opens after the configured
+// number of consecutive failures, and while it is open requests are sent to the failover channel if there is one,
+// otherwise they fail fast.
+type Forwarding struct {
+	ConnectTimeout  int    `json:"connect_timeout,omitempty"  validate:"gte=0,lt=30"`
+	ResponseTimeout int    `json:"response_timeout,omitempty" validate:"gte=0,lt=30"`
+	BreakerFailures int    `json:"breaker_failures,omitempty" validate:"gte=0"`
+	BreakerCooldown int    `json:"breaker_cooldown,omitempty" validate:"gte=0"`
+	Failover        string `json:"failover,omitempty"         validate:"omitempty,uuid4"`
+}
+
+// Value returns our forwarding config as JSON for storing in the db
+func (f *Forwarding) Value() (driver.Value, error) {
+	return jsonColumn[Forwarding]{f, "forwarding"}.Value()
+}
+
+// Scan reads our forwarding config from the JSON stored in the db
+func (f *Forwarding) Scan(value any) error {
+	return jsonColumn[Forwarding]{f, "forwarding"}.Scan(value)
+}
+
+// ConnectTimeout returns how long we wait to connect to this channel
+func (c *Channel) ConnectTimeout() time.Duration {
+	return forwardingSeconds(c.Forwarding, func(f *Forwarding) int { return f.ConnectTimeout }, DefaultConnectTimeout)
+}
+
+// ResponseTimeout returns how long we wait for this channel to respond once we have sent it a request
+func (c *Channel) ResponseTimeout() time.Duration {
+	return forwardingSeconds(c.Forwarding, func(f *Forwarding) int { return f.ResponseTimeout }, DefaultResponseTimeout)
+}
+
+// BreakerCooldown returns how long this channel's breaker stays open before we try it again
+func (c *Channel) BreakerCooldown() time.Duration {
+	return forwardingSeconds(c.Forwarding, func(f *Forwarding) int { return f.BreakerCooldown }, DefaultBreakerCooldown)
+}
+
+// BreakerFailures returns how many consecutive failures open this channel's breaker
+func (c *Channel) BreakerFailures() int {
+	if c.Forwarding == nil || c.Forwarding.BreakerFailures == 0 {
+		return DefaultBreakerFailures
+	}
+	return c.Forwarding.BreakerFailures
+}
+
+// FailoverUUID returns the UUID of the channel requests go to while this channel's breaker is open, if any
+func (c *Channel) FailoverUUID() string {
+	if c.Forwarding == nil {
+		return ""
+	}
+	return c.Forwarding.Failover
+}
+
+func forwardingSeconds(forwarding *Forwarding, field func(*Forwarding) int, def int) time.Duration {
+	seconds := def
+	if forwarding != nil && field(forwarding) != 0 {
+		seconds = field(forwarding)
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// check makes sure our failover is another channel in the passed in interchange
+func (f *Forwarding) check(interchange *Interchange, channel *Channel) error {
+	if err := validateObject(f); err != nil {
+		return err
+	}
+	if f.Failover == "" {
+		return nil
+	}
+	if f.Failover == channel.UUID {
+		return fmt.Errorf("channel can't fail over to itself")
+	}
+	if interchange.GetChannel(f.Failover) == nil {
+		return fmt.Errorf("failover channel %s is not in this interchange", f.Failover)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// HealthCheck is how our health checker probes a channel, by default with a GET of the channel URL which is
+// expected to return a 200
+type HealthCheck struct {
+	URL    string `json:"url,omitempty"    validate:"omitempty,url"`
+	Method string `json:"method,omitempty" validate:"omitempty,oneof=GET HEAD POST"`
+	Status int    `json:"status,omitempty" validate:"omitempty,gte=100,lt=600"`
+}
+
+// Value returns our health check as JSON for storing in the db
+func (h *HealthCheck) Value() (driver.Value, error) {
+	return jsonColumn[HealthCheck]{h, "health check"}.Value()
+}
+
+// Scan reads our health check from the JSON stored in the db
+func (h *HealthCheck) Scan(value any) error {
+	return jsonColumn[HealthCheck]{h, "health check"}.Scan(value)
+}
+
+// HealthCheckURL returns the URL our health checker probes for this channel
+func (c *Channel) HealthCheckURL() string {
+	if c.HealthCheck == nil || c.HealthCheck.URL == "" {
+		return c.URL
+	}
+	return c.HealthCheck.URL
+}
+
+// HealthCheckMethod returns the method our health checker probes this channel with
+func (c *Channel) HealthCheckMethod() string {
+	if c.HealthCheck == nil || c.HealthCheck.Method == "" {
+		return "GET"
+	}
+	return c.HealthCheck.Method
+}
+
+// HealthCheckStatus returns the status our health checker expects from this channel
+func (c *Channel) HealthCheckStatus() int {
+	if c.HealthCheck == nil || c.HealthCheck.Status == 0 {
+		return 200
+	}
+	return c.HealthCheck.Status
+}
+
+// HealthCheckResult is the result of probing a channel
+type HealthCheckResult struct {
+	ID          int64     `db:"id"           json:"-"`
+	ChannelUUID string    `db:"channel_uuid" json:"channel_uuid"`
+	Healthy     bool      `db:"healthy"      json:"healthy"`
+	StatusCode  int       `db:"status_code"  json:"status_code,omitempty"`
+	Error       string    `db:"error"        json:"error,omitempty"`
+	ElapsedMS   int       `db:"elapsed_ms"   json:"elapsed_ms"`
+	CheckedOn   time.Time `db:"checked_on"   json:"checked_on"`
+}
+
+const insertHealthCheckSQL = `
+INSERT INTO health_checks (channel_uuid, healthy, status_code, error, elapsed_ms, checked_on)
+VALUES (:channel_uuid, :healthy, :status_code, :error, :elapsed_ms, :checked_on)
+`
+
+// InsertHealthCheck records the passed in health check result
+func InsertHealthCheck(ctx context.Context, db *sqlx.DB, result *HealthCheckResult) error {
+	_, err := db.NamedExecContext(ctx, insertHealthCheckSQL, result)
+	if err != nil {
+		slog.Error("error inserting health check", "error", err, "channel_uuid", result.ChannelUUID)
+	}
+	return err
+}
+
+// GetHealthChecks returns the most recent health check results for the passed in channel, newest first
+func GetHealthChecks(ctx context.Context, db *sqlx.DB, channelUUID string, limit int) ([]*HealthCheckResult, error) {
+	results := make([]*HealthCheckResult, 0, limit)
+	err := db.SelectContext(ctx, &results, `SELECT * FROM health_checks WHERE channel_uuid = $1 ORDER BY id DESC LIMIT $2`, channelUUID, limit)
+	if err != nil {
+		slog.Error("error selecting health checks", "error", err, "channel_uuid", channelUUID)
+		return nil, err
+	}
+	return results, nil
+}
+
+// PruneHealthChecks removes the health check results from before the passed in time
+func PruneHealthChecks(ctx context.Context, db *sqlx.DB, before time.Time) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM health_checks WHERE checked_on < $1`, before)
+	return err
+}
+
+// the key of the advisory lock held by the instance probing our channels
+const healthCheckLockKey = 7273421
+
+// ClaimHealthChecks returns whether this instance should probe our channels. Each round of checks is made under an
+// advisory lock, and a round is skipped if another instance has recorded results since the passed in time, so our
+// channels are only probed by one instance at a time. The lock is held by a session so we hold on to a connection
+// until we are released.
+func ClaimHealthChecks(ctx context.Context, db *sqlx.DB, since time.Time) (func(), bool, error) {
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	locked := false
+	err = conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1)`, healthCheckLockKey)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, false, err
+	}
+
+	release := func() {
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, healthCheckLockKey)
+		if err != nil {
+			slog.Error("error releasing health check lock", "error", err)
+		}
+		conn.Close()
+	}
+
+	recent := false
+	err = conn.GetContext(ctx, &recent, `SELECT EXISTS(SELECT 1 FROM health_checks WHERE checked_on >= $1)`, since)
+	if err != nil || recent {
+		release()
+		return nil, false, err
+	}
+
+	return release, true, nil
+}
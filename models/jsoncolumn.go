@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonColumn reads and writes a value as JSON in a db column, the config types we store this way implement
+// driver.Valuer and sql.Scanner by wrapping themselves in one
+type jsonColumn[T any] struct {
+	value *T
+
+	// what we call the value in scan errors
+	name string
+}
+
+// Value returns our value as JSON, or NULL if it is nil
+func (c jsonColumn[T]) Value() (driver.Value, error) {
+	if c.value == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(c.value)
+	if err != nil || string(b) == "null" {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan reads our value from the passed in JSON, resetting it if that is NULL
+func (c jsonColumn[T]) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		var zero T
+		*c.value = zero
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c.value)
+	case string:
+		return json.Unmarshal([]byte(v), c.value)
+	default:
+		return fmt.Errorf("unable to scan %T into %s", value, c.name)
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// WhenFull is what happens to senders who would be mapped to a channel which has reached its capacity. They go to
+// its overflow channel if it has one, otherwise they are refused with the configured status and body.
+type WhenFull struct {
+	Overflow string `json:"overflow,omitempty" validate:"omitempty,uuid4"`
+	Status   int    `json:"status,omitempty"   validate:"omitempty,gte=200,lt=600"`
+	Body     string `json:"body,omitempty"`
+}
+
+// Value returns our full behaviour as JSON for storing in the db
+func (f *WhenFull) Value() (driver.Value, error) {
+	return jsonColumn[WhenFull]{f, "when full"}.Value()
+}
+
+// Scan reads our full behaviour from the JSON stored in the db
+func (f *WhenFull) Scan(value any) error {
+	return jsonColumn[WhenFull]{f, "when full"}.Scan(value)
+}
+
+// check makes sure our channel has a capacity to be full at, and our overflow is another channel in the passed in
+// interchange
+func (f *WhenFull) check(interchange *Interchange, channel *Channel) error {
+	if err := validateObject(f); err != nil {
+		return err
+	}
+	if channel.Capacity == 0 {
+		return fmt.Errorf("channel has no capacity")
+	}
+	if f.Overflow == "" {
+		return nil
+	}
+	if f.Overflow == channel.UUID {
+		return fmt.Errorf("channel can't overflow to itself")
+	}
+	if interchange.GetChannel(f.Overflow) == nil {
+		return fmt.Errorf("overflow channel %s is not in this interchange", f.Overflow)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// the postgres channel we notify on when interchanges are changed, the payload is the interchange UUID
+const interchangeNotifyChannel = "clover_interchanges"
+
+const notifyInterchangesSQL = `SELECT pg_notify($1, uuid) FROM unnest($2::text[]) AS uuid`
+
+// the postgres channel we notify on when URN mappings are changed, the payload is the interchange UUID and URN
+// separated by a |, which can't appear in a UUID
+const urnMappingNotifyChannel = "clover_urn_mappings"
+
+const notifyURNMappingSQL = `SELECT pg_notify($1, $2)`
+
+// how often we ping our listener connection to check it is still alive
+const listenerPingInterval = 90 * time.Second
+
+// the number of times we have dropped interchanges from our cache
+var interchangeChanges atomic.Uint64
+
+// InterchangeChanges returns how many times we have seen interchanges change, on this instance or another, so that
+// anything derived from our interchange config can tell when it needs reloading
+func InterchangeChanges() uint64 {
+	return interchangeChanges.Load()
+}
+
+// dropCachedInterchanges removes the passed in interchanges from our cache so they are reloaded on next use
+func dropCachedInterchanges(uuids ...string) {
+	cacheLock.Lock()
+	for _, uuid := range uuids {
+		delete(interchangeCache, strings.ToLower(uuid))
+	}
+	cacheLock.Unlock()
+	interchangeChanges.Add(1)
+}
+
+// clearInterchangeCache removes all interchanges from our cache
+func clearInterchangeCache() {
+	cacheLock.Lock()
+	interchangeCache = make(map[string]*Interchange)
+	cacheLock.Unlock()
+	interchangeChanges.Add(1)
+}
+
+// notifyURNMapping lets every instance know that the mapping for the passed in URN changed. Failing to is only
+// logged, as the mapping itself is saved and other instances will reload it once their cached copy expires.
+func notifyURNMapping(ctx context.Context, db *sqlx.DB, interchangeUUID string, urn string) {
+	_, err := db.ExecContext(ctx, notifyURNMappingSQL, urnMappingNotifyChannel, urnCacheKey(interchangeUUID, urn))
+	if err != nil {
+		slog.Error("error notifying urn mapping change", "error", err)
+	}
+}
+
+// dropCachedURNMapping removes the passed in mapping, as given in a notification, from our cache
+func dropCachedURNMapping(payload string) {
+	interchangeUUID, urn, _ := strings.Cut(payload, "|")
+	urnMappingCache().drop(interchangeUUID, urn)
+}
+
+// CacheListener listens for interchange and URN mapping changes made by any clover instance and drops them from our
+// caches straight away. The listener reconnects automatically if its connection is lost.
+type CacheListener struct {
+	listener *pq.Listener
+	stop     chan bool
+	wg       sync.WaitGroup
+}
+
+// StartCacheListener connects to the passed in database and starts listening for interchange and mapping changes
+func StartCacheListener(dsn string) (*CacheListener, error) {
+	l := &CacheListener{stop: make(chan bool)}
+	l.listener = pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			slog.Error("interchange listener disconnected", "error", err)
+		case pq.ListenerEventConnectionAttemptFailed:
+			slog.Error("interchange listener failed to reconnect", "error", err)
+		case pq.ListenerEventReconnected:
+			slog.Info("interchange listener reconnected")
+		}
+	})
+
+	for _, channel := range []string{interchangeNotifyChannel, urnMappingNotifyChannel} {
+		err := l.listener.Listen(channel)
+		if err != nil {
+			l.listener.Close()
+			return nil, err
+		}
+	}
+
+	l.wg.Add(1)
+	go l.run()
+
+	return l, nil
+}
+
+func (l *CacheListener) run() {
+	defer l.wg.Done()
+
+	for {
+		select {
+		case n := <-l.listener.Notify:
+			// a nil notification means we reconnected and may have missed changes, so drop everything
+			if n == nil {
+				clearInterchangeCache()
+				urnMappingCache().clear()
+				continue
+			}
+
+			if n.Channel == urnMappingNotifyChannel {
+				dropCachedURNMapping(n.Extra)
+				continue
+			}
+
+			slog.Debug("interchange changed, dropping from cache", "interchange_uuid", n.Extra)
+			dropCachedInterchanges(n.Extra)
+
+		case <-time.After(listenerPingInterval):
+			go l.listener.Ping()
+
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Stop stops listening for changes and closes our connection
+func (l *CacheListener) Stop() {
+	close(l.stop)
+	l.wg.Wait()
+
+	err := l.listener.Close()
+	if err != nil {
+		slog.Error("error closing interchange listener", "error", err)
+	}
+}
@@ -0,0 +1,701 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MemoryStore is a store which keeps everything in memory, it is lost when the process exits so is only useful for
+// testing and demos
+type MemoryStore struct {
+	mutex sync.RWMutex
+
+	interchanges map[string]*Interchange
+	mappings     map[string]map[string]*URNMapping
+	users        map[string]*User
+	tokens       []*APIToken
+	audit        []*AuditEntry
+	healthChecks map[string][]*HealthCheckResult
+
+	limiter *MemoryRateLimiter
+	dedup   *memoryDeduplicator
+}
+
+// NewMemoryStore creates a new empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		interchanges: make(map[string]*Interchange),
+		mappings:     make(map[string]map[string]*URNMapping),
+		users:        make(map[string]*User),
+		healthChecks: make(map[string][]*HealthCheckResult),
+		limiter:      NewMemoryRateLimiter(),
+		dedup:        newMemoryDeduplicator(),
+	}
+}
+
+// copyInterchange returns a deep copy of the passed in interchange so callers can't modify what we store
+func copyInterchange(interchange *Interchange) *Interchange {
+	c := *interchange
+	if interchange.Auth != nil {
+		auth := *interchange.Auth
+		c.Auth = &auth
+	}
+	if interchange.AllowedIPs != nil {
+		c.AllowedIPs = append(pq.StringArray{}, interchange.AllowedIPs...)
+	}
+	c.Schedules = interchange.Schedules.clone()
+	if interchange.PrefixRules != nil {
+		c.PrefixRules = append(PrefixRules{}, interchange.PrefixRules...)
+	}
+	if interchange.RateLimit != nil {
+		limit := *interchange.RateLimit
+		for _, bucket := range []**Bucket{&limit.URN, &limit.Interchange} {
+			if *bucket != nil {
+				b := **bucket
+				*bucket = &b
+			}
+		}
+		c.RateLimit = &limit
+	}
+	if interchange.Dedup != nil {
+		dedup := *interchange.Dedup
+		c.Dedup = &dedup
+	}
+	c.Channels = make([]Channel, len(interchange.Channels))
+	for i, channel := range interchange.Channels {
+		if channel.Keywords != nil {
+			channel.Keywords = append(pq.StringArray{}, channel.Keywords...)
+		}
+		if channel.Auth != nil {
+			auth := *channel.Auth
+			channel.Auth = &auth
+		}
+		if channel.Forwarding != nil {
+			forwarding := *channel.Forwarding
+			channel.Forwarding = &forwarding
+		}
+		if channel.HealthCheck != nil {
+			healthCheck := *channel.HealthCheck
+			channel.HealthCheck = &healthCheck
+		}
+		if channel.WhenFull != nil {
+			whenFull := *channel.WhenFull
+			channel.WhenFull = &whenFull
+		}
+		c.Channels[i] = channel
+	}
+	return &c
+}
+
+// UpdateInterchangeConfig replaces our interchange config with the passed in interchanges
+func (s *MemoryStore) UpdateInterchangeConfig(ctx context.Context, interchanges []*Interchange) error {
+	err := prepareInterchangeConfig(interchanges)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updated := make(map[string]*Interchange, len(interchanges))
+	for _, interchange := range interchanges {
+		// store our channels ordered by UUID with the default first, as they are returned from the database
+		stored := copyInterchange(interchange)
+		sort.Slice(stored.Channels, func(i, j int) bool { return stored.Channels[i].UUID < stored.Channels[j].UUID })
+		err = defaultChannelFirst(stored, stored.Channels)
+		if err != nil {
+			return err
+		}
+
+		updated[stored.UUID] = stored
+	}
+	s.interchanges = updated
+
+	// remove any mappings to interchanges or channels which no longer exist
+	for interchangeUUID, mappings := range s.mappings {
+		interchange := s.interchanges[interchangeUUID]
+		if interchange == nil {
+			delete(s.mappings, interchangeUUID)
+			continue
+		}
+
+		for urn, mapping := range mappings {
+			if interchange.GetChannel(mapping.ChannelUUID) == nil {
+				delete(mappings, urn)
+			}
+		}
+	}
+
+	// and the health checks of channels which no longer exist
+	for channelUUID := range s.healthChecks {
+		if s.getChannel(channelUUID) == nil {
+			delete(s.healthChecks, channelUUID)
+		}
+	}
+
+	return nil
+}
+
+// GetInterchangeConfig returns our complete interchange config ordered by UUID
+func (s *MemoryStore) GetInterchangeConfig(ctx context.Context) ([]*Interchange, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	interchanges := make([]*Interchange, 0, len(s.interchanges))
+	for _, interchange := range s.interchanges {
+		interchanges = append(interchanges, copyInterchange(interchange))
+	}
+	sort.Slice(interchanges, func(i, j int) bool { return interchanges[i].UUID < interchanges[j].UUID })
+
+	return interchanges, nil
+}
+
+// GetInterchange returns the interchange with the passed in UUID
+func (s *MemoryStore) GetInterchange(ctx context.Context, uuid string) (*Interchange, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	interchange := s.interchanges[strings.ToLower(uuid)]
+	if interchange == nil {
+		return nil, nil
+	}
+	return copyInterchange(interchange), nil
+}
+
+// SetChannelForURN maps the passed in URN to the passed in channel
+func (s *MemoryStore) SetChannelForURN(ctx context.Context, interchange *Interchange, channel *Channel, urn string) error {
+	// double check our channel membership
+	if channel.InterchangeUUID != interchange.UUID {
+		return fmt.Errorf("channel does not belong to interchange %s != %s", channel.InterchangeUUID, interchange.UUID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := s.interchanges[interchange.UUID]
+	if stored == nil || stored.GetChannel(channel.UUID) == nil {
+		return fmt.Errorf("no such channel %s for interchange %s", channel.UUID, interchange.UUID)
+	}
+
+	s.setMapping(interchange.UUID, channel.UUID, urn)
+	return nil
+}
+
+// setMapping maps the passed in URN to the passed in channel, our mutex must be held
+func (s *MemoryStore) setMapping(interchangeUUID string, channelUUID string, urn string) {
+	mappings := s.mappings[interchangeUUID]
+	if mappings == nil {
+		mappings = make(map[string]*URNMapping)
+		s.mappings[interchangeUUID] = mappings
+	}
+
+	now := time.Now().UTC()
+	mapping := mappings[urn]
+	if mapping == nil {
+		mapping = &URNMapping{URN: urn, InterchangeUUID: interchangeUUID, CreatedOn: now}
+		mappings[urn] = mapping
+	}
+	mapping.ChannelUUID = channelUUID
+	mapping.ModifiedOn = now
+}
+
+// AssignChannelForURN maps the passed in URN to the passed in channel unless it has reached its capacity
+func (s *MemoryStore) AssignChannelForURN(ctx context.Context, interchange *Interchange, channel *Channel, urn string) (bool, error) {
+	// double check our channel membership
+	if channel.InterchangeUUID != interchange.UUID {
+		return false, fmt.Errorf("channel does not belong to interchange %s != %s", channel.InterchangeUUID, interchange.UUID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := s.interchanges[interchange.UUID]
+	if stored == nil || stored.GetChannel(channel.UUID) == nil {
+		return false, fmt.Errorf("no such channel %s for interchange %s", channel.UUID, interchange.UUID)
+	}
+
+	if channel.Capacity > 0 {
+		count := 0
+		for _, mapping := range s.mappings[interchange.UUID] {
+			if mapping.ChannelUUID == channel.UUID {
+				count++
+			}
+		}
+		if count >= channel.Capacity {
+			return false, nil
+		}
+	}
+
+	s.setMapping(interchange.UUID, channel.UUID, urn)
+	return true, nil
+}
+
+// GetChannelForURN returns the channel the passed in URN is mapped to
+func (s *MemoryStore) GetChannelForURN(ctx context.Context, interchange *Interchange, urn string) (*Channel, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	mapping := s.mappings[interchange.UUID][urn]
+	if mapping == nil {
+		return nil, nil
+	}
+
+	stored := s.interchanges[interchange.UUID]
+	if stored == nil {
+		return nil, nil
+	}
+
+	channel := stored.GetChannel(mapping.ChannelUUID)
+	if channel == nil {
+		return nil, nil
+	}
+
+	c := *channel
+	return &c, nil
+}
+
+// ClearChannelForURN removes any mapping for the passed in URN
+func (s *MemoryStore) ClearChannelForURN(ctx context.Context, interchange *Interchange, urn string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.mappings[interchange.UUID], urn)
+	return nil
+}
+
+// GetURNMapping returns the mapping for the passed in URN
+func (s *MemoryStore) GetURNMapping(ctx context.Context, interchange *Interchange, urn string) (*URNMapping, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	mapping := s.mappings[interchange.UUID][urn]
+	if mapping == nil {
+		return nil, nil
+	}
+
+	m := *mapping
+	return &m, nil
+}
+
+// sortedMappings returns copies of the mappings of the passed in interchange which pass the passed in filter, ordered by URN
+func (s *MemoryStore) sortedMappings(interchangeUUID string, include func(*URNMapping) bool) []*URNMapping {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	mappings := make([]*URNMapping, 0, len(s.mappings[interchangeUUID]))
+	for _, mapping := range s.mappings[interchangeUUID] {
+		if include(mapping) {
+			m := *mapping
+			mappings = append(mappings, &m)
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].URN < mappings[j].URN })
+
+	return mappings
+}
+
+// ListURNMappings returns a page of the mappings for the passed in interchange
+func (s *MemoryStore) ListURNMappings(ctx context.Context, interchange *Interchange, query *URNMappingQuery) ([]*URNMapping, error) {
+	mappings := s.sortedMappings(interchange.UUID, func(m *URNMapping) bool {
+		return strings.HasPrefix(m.URN, query.Prefix) &&
+			(query.ChannelUUID == "" || m.ChannelUUID == query.ChannelUUID) &&
+			m.URN > query.After
+	})
+
+	if len(mappings) > query.Limit {
+		mappings = mappings[:query.Limit]
+	}
+	return mappings, nil
+}
+
+// CountURNMappings returns the number of URNs mapped to each channel of the passed in interchange
+func (s *MemoryStore) CountURNMappings(ctx context.Context, interchange *Interchange) ([]*ChannelCount, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stored := s.interchanges[interchange.UUID]
+	if stored == nil {
+		return []*ChannelCount{}, nil
+	}
+
+	counts := make([]*ChannelCount, 0, len(stored.Channels))
+	byChannel := make(map[string]*ChannelCount, len(stored.Channels))
+	for _, channel := range stored.Channels {
+		count := &ChannelCount{ChannelUUID: channel.UUID}
+		counts = append(counts, count)
+		byChannel[channel.UUID] = count
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].ChannelUUID < counts[j].ChannelUUID })
+
+	for _, mapping := range s.mappings[interchange.UUID] {
+		if count := byChannel[mapping.ChannelUUID]; count != nil {
+			count.Count++
+		}
+	}
+
+	return counts, nil
+}
+
+// GetMappedURNs returns which of the passed in URNs are mapped to a channel of the passed in interchange
+func (s *MemoryStore) GetMappedURNs(ctx context.Context, interchange *Interchange, urns []string) (map[string]bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	mapped := make(map[string]bool)
+	for _, urn := range urns {
+		if s.mappings[interchange.UUID][urn] != nil {
+			mapped[urn] = true
+		}
+	}
+	return mapped, nil
+}
+
+// ExportURNMappings calls fn for every mapping of the passed in interchange
+func (s *MemoryStore) ExportURNMappings(ctx context.Context, interchangeUUID string, channelUUID string, fn func(*URNMapping) error) error {
+	mappings := s.sortedMappings(interchangeUUID, func(m *URNMapping) bool {
+		return channelUUID == "" || m.ChannelUUID == channelUUID
+	})
+
+	for _, mapping := range mappings {
+		err := fn(mapping)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveUser creates or updates the user with the passed in username, an empty password updates only their role
+func (s *MemoryStore) SaveUser(ctx context.Context, username string, password string, role Role) error {
+	if password == "" {
+		user, err := newRoleUpdate(username, role)
+		if err != nil {
+			return err
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		existing := s.users[username]
+		if existing == nil {
+			return errPasswordRequired
+		}
+		existing.Role = user.Role
+		existing.ModifiedOn = time.Now().UTC()
+		return nil
+	}
+
+	user, err := newUser(username, password, role)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user.CreatedOn = time.Now().UTC()
+	user.ModifiedOn = user.CreatedOn
+	if existing := s.users[username]; existing != nil {
+		user.CreatedOn = existing.CreatedOn
+	}
+	s.users[username] = user
+
+	return nil
+}
+
+// GetUser returns the user with the passed in username
+func (s *MemoryStore) GetUser(ctx context.Context, username string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user := s.users[username]
+	if user == nil {
+		return nil, nil
+	}
+
+	u := *user
+	return &u, nil
+}
+
+// GetUsers returns all our users ordered by username
+func (s *MemoryStore) GetUsers(ctx context.Context) ([]*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		u := *user
+		users = append(users, &u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	return users, nil
+}
+
+// DeleteUser deletes the user with the passed in username
+func (s *MemoryStore) DeleteUser(ctx context.Context, username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, found := s.users[username]
+	delete(s.users, username)
+	return found, nil
+}
+
+// AuthenticateUser returns the user with the passed in username if the password matches
+func (s *MemoryStore) AuthenticateUser(ctx context.Context, username string, password string) (*User, error) {
+	user, err := s.GetUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.checkPassword(password) {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+// copyAPIToken returns a deep copy of the passed in token so callers can't modify what we store
+func copyAPIToken(token *APIToken) *APIToken {
+	t := *token
+	t.Permissions = append(pq.StringArray{}, token.Permissions...)
+	t.InterchangeUUIDs = append(pq.StringArray{}, token.InterchangeUUIDs...)
+	return &t
+}
+
+// CreateAPIToken creates a new API token
+func (s *MemoryStore) CreateAPIToken(ctx context.Context, name string, permissions []Permission, interchangeUUIDs []string, createdBy string) (*APIToken, string, error) {
+	token, value, err := newAPIToken(name, permissions, interchangeUUIDs, createdBy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token.ID = len(s.tokens) + 1
+	token.CreatedOn = time.Now().UTC()
+	s.tokens = append(s.tokens, token)
+
+	return copyAPIToken(token), value, nil
+}
+
+// GetAPITokens returns all our API tokens ordered by id
+func (s *MemoryStore) GetAPITokens(ctx context.Context) ([]*APIToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	tokens := make([]*APIToken, len(s.tokens))
+	for i, token := range s.tokens {
+		tokens[i] = copyAPIToken(token)
+	}
+	return tokens, nil
+}
+
+// GetAPIToken returns the token with the passed in id
+func (s *MemoryStore) GetAPIToken(ctx context.Context, id int) (*APIToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// our ids are assigned sequentially from one
+	if id < 1 || id > len(s.tokens) {
+		return nil, nil
+	}
+	return copyAPIToken(s.tokens[id-1]), nil
+}
+
+// RevokeAPIToken revokes the token with the passed in id
+func (s *MemoryStore) RevokeAPIToken(ctx context.Context, id int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// our ids are assigned sequentially from one
+	if id < 1 || id > len(s.tokens) || s.tokens[id-1].RevokedOn != nil {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	s.tokens[id-1].RevokedOn = &now
+	return true, nil
+}
+
+// AuthenticateAPIToken returns the active token matching the passed in value
+func (s *MemoryStore) AuthenticateAPIToken(ctx context.Context, value string) (*APIToken, error) {
+	hash := hashToken(value)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, token := range s.tokens {
+		if token.TokenHash == hash && token.RevokedOn == nil {
+			now := time.Now().UTC()
+			token.LastUsedOn = &now
+			return copyAPIToken(token), nil
+		}
+	}
+	return nil, nil
+}
+
+// InsertAuditEntry appends the passed in entry to our audit log
+func (s *MemoryStore) InsertAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e := *entry
+	e.ID = int64(len(s.audit) + 1)
+	e.CreatedOn = time.Now().UTC()
+	s.audit = append(s.audit, &e)
+
+	return nil
+}
+
+// matches returns whether the passed in entry matches this query
+func (q *AuditQuery) matches(e *AuditEntry) bool {
+	return (q.Actor == "" || e.Actor == q.Actor) &&
+		(q.Action == "" || e.Action == q.Action) &&
+		strings.HasPrefix(e.Target, q.Target) &&
+		(q.Since.IsZero() || !e.CreatedOn.Before(q.Since)) &&
+		(q.Until.IsZero() || e.CreatedOn.Before(q.Until)) &&
+		(q.BeforeID == 0 || e.ID < q.BeforeID)
+}
+
+// matchingAuditEntries returns copies of the entries matching the passed in query, newest first, up to limit if it
+// is greater than zero
+func (s *MemoryStore) matchingAuditEntries(query *AuditQuery, limit int) []*AuditEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entries := make([]*AuditEntry, 0)
+	for i := len(s.audit) - 1; i >= 0 && (limit <= 0 || len(entries) < limit); i-- {
+		if query.matches(s.audit[i]) {
+			e := *s.audit[i]
+			entries = append(entries, &e)
+		}
+	}
+	return entries
+}
+
+// GetAuditEntries returns the audit entries matching the passed in query, newest first
+func (s *MemoryStore) GetAuditEntries(ctx context.Context, query *AuditQuery) ([]*AuditEntry, error) {
+	if query.Limit <= 0 {
+		return []*AuditEntry{}, nil
+	}
+	return s.matchingAuditEntries(query, query.Limit), nil
+}
+
+// ExportAuditEntries calls fn for every audit entry matching the passed in query, newest first
+func (s *MemoryStore) ExportAuditEntries(ctx context.Context, query *AuditQuery, fn func(*AuditEntry) error) error {
+	for _, entry := range s.matchingAuditEntries(query, 0) {
+		err := fn(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing for our in-memory store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// TakeToken takes a token from the bucket with the passed in key, returning whether there was one to take
+func (s *MemoryStore) TakeToken(ctx context.Context, key string, bucket *Bucket) (bool, error) {
+	return s.limiter.TakeToken(ctx, key, bucket)
+}
+
+// PruneTokens removes the state of any buckets which have refilled
+func (s *MemoryStore) PruneTokens(ctx context.Context) error {
+	return s.limiter.PruneTokens(ctx)
+}
+
+// ClaimDedupKey claims the passed in key for an interchange, returning the response to its message if already claimed
+func (s *MemoryStore) ClaimDedupKey(ctx context.Context, interchangeUUID string, key string, window time.Duration) (bool, *DedupResponse, error) {
+	return s.dedup.ClaimDedupKey(ctx, interchangeUUID, key, window)
+}
+
+// CompleteDedupKey records the response to the message with a key we claimed
+func (s *MemoryStore) CompleteDedupKey(ctx context.Context, interchangeUUID string, key string, response *DedupResponse) error {
+	return s.dedup.CompleteDedupKey(ctx, interchangeUUID, key, response)
+}
+
+// ReleaseDedupKey releases a key we claimed but couldn't forward
+func (s *MemoryStore) ReleaseDedupKey(ctx context.Context, interchangeUUID string, key string) error {
+	return s.dedup.ReleaseDedupKey(ctx, interchangeUUID, key)
+}
+
+// PruneDedupKeys removes any keys which have expired
+func (s *MemoryStore) PruneDedupKeys(ctx context.Context) error {
+	return s.dedup.PruneDedupKeys(ctx)
+}
+
+// getChannel returns the channel with the passed in UUID in any of our interchanges, must be called with our lock held
+func (s *MemoryStore) getChannel(uuid string) *Channel {
+	for _, interchange := range s.interchanges {
+		if channel := interchange.GetChannel(uuid); channel != nil {
+			return channel
+		}
+	}
+	return nil
+}
+
+// InsertHealthCheck records the passed in health check result
+func (s *MemoryStore) InsertHealthCheck(ctx context.Context, result *HealthCheckResult) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.getChannel(result.ChannelUUID) == nil {
+		return fmt.Errorf("no such channel %s", result.ChannelUUID)
+	}
+
+	r := *result
+	r.ID = 1
+	if checks := s.healthChecks[r.ChannelUUID]; len(checks) > 0 {
+		r.ID = checks[len(checks)-1].ID + 1
+	}
+	s.healthChecks[r.ChannelUUID] = append(s.healthChecks[r.ChannelUUID], &r)
+	return nil
+}
+
+// GetHealthChecks returns the most recent health check results for the passed in channel, newest first
+func (s *MemoryStore) GetHealthChecks(ctx context.Context, channelUUID string, limit int) ([]*HealthCheckResult, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	checks := s.healthChecks[channelUUID]
+	results := make([]*HealthCheckResult, 0, limit)
+	for i := len(checks) - 1; i >= 0 && len(results) < limit; i-- {
+		r := *checks[i]
+		results = append(results, &r)
+	}
+	return results, nil
+}
+
+// PruneHealthChecks removes the health check results from before the passed in time
+func (s *MemoryStore) PruneHealthChecks(ctx context.Context, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for channelUUID, checks := range s.healthChecks {
+		kept := checks[:0]
+		for _, check := range checks {
+			if !check.CheckedOn.Before(before) {
+				kept = append(kept, check)
+			}
+		}
+		s.healthChecks[channelUUID] = kept
+	}
+	return nil
+}
+
+// ClaimHealthChecks always claims our health checks as nothing else can see this store
+func (s *MemoryStore) ClaimHealthChecks(ctx context.Context, since time.Time) (func(), bool, error) {
+	return func() {}, true, nil
+}
@@ -21,59 +21,102 @@ type Channel struct {
 	InterchangeUUID string         `db:"interchange_uuid"  json:"-"`
 	URL             string         `db:"url"               json:"url"       validate:"required,url"`
 	Keywords        pq.StringArray `db:"keywords"          json:"keywords"`
+	Auth            *ChannelAuth   `db:"auth"              json:"auth,omitempty"`
+	Forwarding      *Forwarding    `db:"forwarding"        json:"forwarding,omitempty"`
+	HealthCheck     *HealthCheck   `db:"health_check"      json:"health_check,omitempty"`
+	Weight          int            `db:"weight"            json:"weight,omitempty"   validate:"gte=0"`
+	Capacity        int            `db:"capacity"          json:"capacity,omitempty" validate:"gte=0"`
+	WhenFull        *WhenFull      `db:"when_full"         json:"when_full,omitempty"`
+}
+
+// the strategies for distributing senders without a mapping across the channels of an interchange
+const (
+	DistributionDefault    = "default"
+	DistributionWeighted   = "weighted"
+	DistributionRoundRobin = "round_robin"
+	DistributionFill       = "fill"
+	DistributionHash       = "hash"
+)
+
+// DistributionWeight returns this channel's share of senders when they are distributed by weight. Weighted
+// distribution requires every channel to have a weight, but hashing doesn't, giving channels without one a weight of 1.
+func (c *Channel) DistributionWeight() int {
+	if c.Weight == 0 {
+		return 1
+	}
+	return c.Weight
 }
 
 // Interchange represents our interchanges
 type Interchange struct {
-	UUID               string    `db:"uuid"                  json:"uuid"     validate:"required,uuid4"`
-	Name               string    `db:"name"                  json:"name"     validate:"required"`
-	Country            string    `db:"country"               json:"country"  validate:"required"`
-	Scheme             string    `db:"scheme"                json:"scheme"   validate:"required"`
-	DefaultChannelUUID string    `db:"default_channel_uuid"  json:"-"`
-	Channels           []Channel `                           json:"channels" validate:"required,dive"`
+	UUID               string           `db:"uuid"                  json:"uuid"     validate:"required,uuid4"`
+	Name               string           `db:"name"                  json:"name"     validate:"required"`
+	Country            string           `db:"country"               json:"country"  validate:"required"`
+	Scheme             string           `db:"scheme"                json:"scheme"   validate:"required"`
+	DefaultChannelUUID string           `db:"default_channel_uuid"  json:"-"`
+	Auth               *InterchangeAuth `db:"auth"                  json:"auth,omitempty"`
+	AllowedIPs         pq.StringArray   `db:"allowed_ips"           json:"allowed_ips,omitempty"`
+	RateLimit          *RateLimit       `db:"rate_limit"            json:"rate_limit,omitempty"`
+	Dedup              *Dedup           `db:"dedup"                 json:"dedup,omitempty"`
+	Distribution       string           `db:"distribution"          json:"distribution,omitempty" validate:"omitempty,oneof=default weighted round_robin fill hash"`
+	Timezone           string           `db:"timezone"              json:"timezone,omitempty"`
+	Schedules          Schedules        `db:"schedules"             json:"schedules,omitempty"`
+	PrefixRules        PrefixRules      `db:"prefix_rules"          json:"prefix_rules,omitempty"`
+	Channels           []Channel        `                           json:"channels" validate:"required,dive"`
 
 	// when we were loaded, for cache invalidation
 	loadedOn time.Time
 }
 
+// GetChannel returns the channel in this interchange with the passed in UUID, if any
+func (i *Interchange) GetChannel(uuid string) *Channel {
+	for c := range i.Channels {
+		if i.Channels[c].UUID == uuid {
+			return &i.Channels[c]
+		}
+	}
+	return nil
+}
+
 // URNMapping represents the mapping for a URN
 type URNMapping struct {
-	URN             string `db:"urn"              json:"urn"`
-	InterchangeUUID string `db:"interchange_uuid" json:"interchange_uuid"`
-	ChannelUUID     string `db:"channel_uuid"     json:"channel_uuid"`
+	URN             string    `db:"urn"              json:"urn"`
+	InterchangeUUID string    `db:"interchange_uuid" json:"interchange_uuid"`
+	ChannelUUID     string    `db:"channel_uuid"     json:"channel_uuid"`
+	CreatedOn       time.Time `db:"created_on"       json:"created_on"`
+	ModifiedOn      time.Time `db:"modified_on"      json:"modified_on"`
 }
 
 const upsertInterchangeSQL = `
-INSERT INTO interchanges (uuid, name, country, scheme, default_channel_uuid)
-VALUES (:uuid, :name, :country, :scheme, :default_channel_uuid) 
+INSERT INTO interchanges (uuid, name, country, scheme, default_channel_uuid, auth, allowed_ips, rate_limit, dedup, distribution, timezone, schedules, prefix_rules)
+VALUES (:uuid, :name, :country, :scheme, :default_channel_uuid, :auth, :allowed_ips, :rate_limit, :dedup, :distribution, :timezone, :schedules, :prefix_rules) 
 ON CONFLICT (uuid) 
 DO
  UPDATE
-   SET name = :name, country = :country, scheme = :scheme, default_channel_uuid = :default_channel_uuid;
+   SET name = :name, country = :country, scheme = :scheme, default_channel_uuid = :default_channel_uuid, auth = :auth, 
+       allowed_ips = :allowed_ips, rate_limit = :rate_limit, dedup = :dedup, distribution = :distribution,
+       timezone = :timezone, schedules = :schedules, prefix_rules = :prefix_rules;
 `
 
 const upsertChannelSQL = `
-INSERT INTO channels (uuid, name, interchange_uuid, url, keywords)
-VALUES (:uuid, :name, :interchange_uuid, :url, :keywords) 
+INSERT INTO channels (uuid, name, interchange_uuid, url, keywords, auth, forwarding, health_check, weight, capacity, when_full)
+VALUES (:uuid, :name, :interchange_uuid, :url, :keywords, :auth, :forwarding, :health_check, :weight, :capacity, :when_full) 
 ON CONFLICT (uuid) 
 DO
  UPDATE
-   SET name = :name, interchange_uuid = :interchange_uuid, url = :url, keywords = :keywords;
+   SET name = :name, interchange_uuid = :interchange_uuid, url = :url, keywords = :keywords, auth = :auth, 
+       forwarding = :forwarding, health_check = :health_check, weight = :weight, capacity = :capacity, 
+       when_full = :when_full;
 `
 
 // UpdateInterchangeConfig updates our interchange configs according to the passed in interchanges. Returns
 // any errors encountered during validation or writing to the db.
 func UpdateInterchangeConfig(ctx context.Context, db *sqlx.DB, interchanges []*Interchange) (err error) {
-	err = validateInterchangeConfig(interchanges)
+	err = prepareInterchangeConfig(interchanges)
 	if err != nil {
 		return err
 	}
 
-	for _, interchange := range interchanges {
-		// set our default channel UUID to the first channel
-		interchange.DefaultChannelUUID = interchange.Channels[0].UUID
-	}
-
 	// ok this looks like it should work, do our updates in a single transaction
 	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
@@ -93,8 +136,6 @@ func UpdateInterchangeConfig(ctx context.Context, db *sqlx.DB, interchanges []*I
 	seenInterchanges := make(map[string]bool)
 	seenChannels := make(map[string]bool)
 	for _, interchange := range interchanges {
-		// assign our default to our first channel
-		interchange.DefaultChannelUUID = interchange.Channels[0].UUID
 		seenInterchanges[interchange.UUID] = true
 
 		// write this interchange
@@ -117,15 +158,16 @@ func UpdateInterchangeConfig(ctx context.Context, db *sqlx.DB, interchanges []*I
 	}
 
 	// if there are no interchanges, delete everything
+	deletedUUIDs := make([]string, 0)
 	if len(interchanges) == 0 {
-		_, err := tx.ExecContext(ctx, `DELETE FROM interchanges;`)
+		err := tx.SelectContext(ctx, &deletedUUIDs, `DELETE FROM interchanges RETURNING uuid;`)
 		if err != nil {
 			return err
 		}
 	} else {
 		// otherwise, remove all that we haven't seen
 		interchangeUUIDs := mapKeys(seenInterchanges)
-		_, err = tx.ExecContext(ctx, `DELETE FROM interchanges WHERE NOT ARRAY[uuid] <@ $1`, pq.Array(interchangeUUIDs))
+		err = tx.SelectContext(ctx, &deletedUUIDs, `DELETE FROM interchanges WHERE NOT ARRAY[uuid] <@ $1 RETURNING uuid`, pq.Array(interchangeUUIDs))
 		if err != nil {
 			return err
 		}
@@ -138,14 +180,17 @@ func UpdateInterchangeConfig(ctx context.Context, db *sqlx.DB, interchanges []*I
 		}
 	}
 
-	// if we saved correctly clear out our cache
-	if err == nil {
-		cacheLock.Lock()
-		interchangeCache = make(map[string]*Interchange)
-		cacheLock.Unlock()
+	// let every instance know which interchanges changed, these are only delivered if we commit
+	changedUUIDs := append(mapKeys(seenInterchanges), deletedUUIDs...)
+	_, err = tx.ExecContext(ctx, notifyInterchangesSQL, interchangeNotifyChannel, pq.Array(changedUUIDs))
+	if err != nil {
+		return err
 	}
 
-	return err
+	// drop them from our own cache, other instances will do the same when they receive our notifications
+	dropCachedInterchanges(changedUUIDs...)
+
+	return nil
 }
 
 // GetInterchangeConfig returns our complete interchange configuration
@@ -171,6 +216,8 @@ func GetInterchangeConfig(ctx context.Context, db *sqlx.DB) ([]*Interchange, err
 // GetInterchange returns the interchange configuration for the passed in UUID. This will include the
 // channels for the interchange with the default channel being the first channel in the slice.
 func GetInterchange(ctx context.Context, db *sqlx.DB, uuid string) (*Interchange, error) {
+	uuid = strings.ToLower(uuid)
+
 	cacheLock.RLock()
 	interchange, found := interchangeCache[uuid]
 	cacheLock.RUnlock()
@@ -211,33 +258,39 @@ func GetInterchange(ctx context.Context, db *sqlx.DB, uuid string) (*Interchange
 func getChannelsForInterchange(ctx context.Context, db *sqlx.DB, interchange *Interchange) ([]Channel, error) {
 	channels := []Channel{}
 	err := db.SelectContext(ctx, &channels, `SELECT * FROM channels WHERE interchange_uuid = $1 ORDER BY uuid`, interchange.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = defaultChannelFirst(interchange, channels)
+	if err != nil {
+		return nil, err
+	}
 
-	// find the default channel and make it the first in the list
-	found := false
+	return channels, nil
+}
+
+// defaultChannelFirst finds the default channel for the passed in interchange and makes it the first in the list
+func defaultChannelFirst(interchange *Interchange, channels []Channel) error {
 	for i, channel := range channels {
 		if channel.UUID == interchange.DefaultChannelUUID {
 			tmp := channels[0]
 			channels[0] = channel
 			channels[i] = tmp
-			found = true
-			break
+			return nil
 		}
 	}
 
-	if !found {
-		return nil, fmt.Errorf("unable to find default channel: %s for interchange: %s", interchange.DefaultChannelUUID, interchange.UUID)
-	}
-
-	return channels, err
+	return fmt.Errorf("unable to find default channel: %s for interchange: %s", interchange.DefaultChannelUUID, interchange.UUID)
 }
 
 const upsertURNMappingSQL = `
-INSERT INTO urn_mappings (interchange_uuid, channel_uuid, urn)
-VALUES ($1, $2, $3) 
+INSERT INTO urn_mappings (interchange_uuid, channel_uuid, urn, created_on, modified_on)
+VALUES ($1, $2, $3, NOW(), NOW()) 
 ON CONFLICT (interchange_uuid, urn) 
 DO
  UPDATE
-   SET channel_uuid = $2
+   SET channel_uuid = $2, modified_on = NOW()
 `
 
 // SetChannelForURN associates the passed in URN with the passed in Channel
@@ -250,26 +303,116 @@ func SetChannelForURN(ctx context.Context, db *sqlx.DB, interchange *Interchange
 	_, err := db.ExecContext(ctx, upsertURNMappingSQL, interchange.UUID, channel.UUID, urn)
 	if err != nil {
 		slog.Error("error upserting urn mapping", "error", err)
+		return err
+	}
+
+	urnMappingCache().write(interchange.UUID, urn, channel.UUID)
+	notifyURNMapping(ctx, db, interchange.UUID, urn)
+	return nil
+}
+
+const lockChannelSQL = `SELECT uuid FROM channels WHERE uuid = $1 AND interchange_uuid = $2 FOR UPDATE`
+
+const countChannelURNMappingsSQL = `SELECT COUNT(*) FROM urn_mappings WHERE interchange_uuid = $1 AND channel_uuid = $2`
+
+// AssignChannelForURN maps the passed in URN to the passed in channel unless the channel has reached its capacity,
+// returning whether it was mapped. Assignments to a channel hold a lock on it while they count its mappings and add
+// theirs, so concurrent assignments can't take it over its capacity.
+func AssignChannelForURN(ctx context.Context, db *sqlx.DB, interchange *Interchange, channel *Channel, urn string) (assigned bool, err error) {
+	// double check our channel membership
+	if channel.InterchangeUUID != interchange.UUID {
+		return false, fmt.Errorf("channel does not belong to interchange %s != %s", channel.InterchangeUUID, interchange.UUID)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// this will either rollback or commit based on whether we assigned the channel
+	defer func() {
+		if err != nil || !assigned {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			assigned = false
+			return
+		}
+		urnMappingCache().write(interchange.UUID, urn, channel.UUID)
+	}()
+
+	var locked string
+	err = tx.GetContext(ctx, &locked, lockChannelSQL, channel.UUID, interchange.UUID)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("no such channel %s for interchange %s", channel.UUID, interchange.UUID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if channel.Capacity > 0 {
+		count := 0
+		err = tx.GetContext(ctx, &count, countChannelURNMappingsSQL, interchange.UUID, channel.UUID)
+		if err != nil {
+			return false, err
+		}
+		if count >= channel.Capacity {
+			return false, nil
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, upsertURNMappingSQL, interchange.UUID, channel.UUID, urn)
+	if err != nil {
+		slog.Error("error upserting urn mapping", "error", err)
+		return false, err
+	}
+
+	// let every instance know the mapping changed, this is only delivered if we commit
+	_, err = tx.ExecContext(ctx, notifyURNMappingSQL, urnMappingNotifyChannel, urnCacheKey(interchange.UUID, urn))
+	if err != nil {
+		return false, err
 	}
 
-	return err
+	return true, nil
 }
 
 const getURNMappingSQL = `
-SELECT c.uuid as uuid, c.name as name, c.interchange_uuid as interchange_uuid, c.url as url, c.keywords as keywords
+SELECT c.*
 FROM urn_mappings u, channels c
 WHERE u.interchange_uuid = $1 AND u.urn = $2 AND u.channel_uuid = c.uuid
 `
 
-// GetChannelForURN returns the channel that is associated with the passed in URN, if any
+// GetChannelForURN returns the channel that is associated with the passed in URN, if any. Lookups, including those
+// which find no mapping, are cached in memory until they expire or a change to the mapping is published.
 func GetChannelForURN(ctx context.Context, db *sqlx.DB, interchange *Interchange, urn string) (*Channel, error) {
+	cache := urnMappingCache()
+	channelUUID, found, version := cache.get(interchange.UUID, urn)
+	if found {
+		if channelUUID == "" {
+			return nil, nil
+		}
+
+		// only trust our cached channel if it is still part of our interchange
+		if channel := interchange.GetChannel(channelUUID); channel != nil {
+			c := *channel
+			return &c, nil
+		}
+	}
+
 	channel := Channel{}
 	err := db.GetContext(ctx, &channel, getURNMappingSQL, interchange.UUID, urn)
 	if err == sql.ErrNoRows {
+		cache.put(interchange.UUID, urn, "", version)
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	return &channel, err
+	cache.put(interchange.UUID, urn, channel.UUID, version)
+	return &channel, nil
 }
 
 const deleteURNMappingSQL = `
@@ -283,12 +426,124 @@ func ClearChannelForURN(ctx context.Context, db *sqlx.DB, interchange *Interchan
 	_, err := db.ExecContext(ctx, deleteURNMappingSQL, interchange.UUID, urn)
 	if err != nil {
 		slog.Error("error deleting urn mapping", "error", err)
+		return err
 	}
 
-	return err
+	urnMappingCache().write(interchange.UUID, urn, "")
+	notifyURNMapping(ctx, db, interchange.UUID, urn)
+	return nil
+}
+
+const getURNMappingRecordSQL = `
+SELECT urn, interchange_uuid, channel_uuid, created_on, modified_on
+FROM urn_mappings
+WHERE interchange_uuid = $1 AND urn = $2
+`
+
+// GetURNMapping returns the mapping for the passed in URN, if any
+func GetURNMapping(ctx context.Context, db *sqlx.DB, interchange *Interchange, urn string) (*URNMapping, error) {
+	mapping := &URNMapping{}
+	err := db.GetContext(ctx, mapping, getURNMappingRecordSQL, interchange.UUID, urn)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return mapping, err
+}
+
+// URNMappingQuery describes a page of URN mappings to list
+type URNMappingQuery struct {
+	// only include URNs starting with this prefix
+	Prefix string
+
+	// only include mappings to this channel
+	ChannelUUID string
+
+	// only include URNs which sort after this one, used for paging
+	After string
+
+	// the maximum number of mappings to return
+	Limit int
+}
+
+const listURNMappingsSQL = `
+SELECT urn, interchange_uuid, channel_uuid, created_on, modified_on
+FROM urn_mappings
+WHERE 
+  interchange_uuid = $1 AND 
+  ($2 = '' OR urn LIKE $2) AND 
+  ($3 = '' OR channel_uuid::text = $3) AND
+  urn > $4
+ORDER BY urn
+LIMIT $5
+`
+
+// ListURNMappings returns a page of the mappings for the passed in interchange ordered by URN
+func ListURNMappings(ctx context.Context, db *sqlx.DB, interchange *Interchange, query *URNMappingQuery) ([]*URNMapping, error) {
+	like := ""
+	if query.Prefix != "" {
+		like = likeEscaper.Replace(query.Prefix) + "%"
+	}
+
+	mappings := make([]*URNMapping, 0, query.Limit)
+	err := db.SelectContext(ctx, &mappings, listURNMappingsSQL, interchange.UUID, like, query.ChannelUUID, query.After, query.Limit)
+	if err != nil {
+		slog.Error("error listing urn mappings", "error", err)
+		return nil, err
+	}
+
+	return mappings, nil
+}
+
+// ChannelCount is the number of URNs mapped to a channel
+type ChannelCount struct {
+	ChannelUUID string `db:"channel_uuid" json:"channel_uuid"`
+	Count       int    `db:"count"        json:"count"`
+}
+
+const countURNMappingsSQL = `
+SELECT c.uuid as channel_uuid, COUNT(u.urn) as count
+FROM channels c LEFT OUTER JOIN urn_mappings u ON u.channel_uuid = c.uuid
+WHERE c.interchange_uuid = $1
+GROUP BY c.uuid
+ORDER BY c.uuid
+`
+
+// CountURNMappings returns the number of URNs mapped to each channel in the passed in interchange
+func CountURNMappings(ctx context.Context, db *sqlx.DB, interchange *Interchange) ([]*ChannelCount, error) {
+	counts := make([]*ChannelCount, 0, len(interchange.Channels))
+	err := db.SelectContext(ctx, &counts, countURNMappingsSQL, interchange.UUID)
+	if err != nil {
+		slog.Error("error counting urn mappings", "error", err)
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+const selectMappedURNsSQL = `
+SELECT u.urn
+FROM unnest($2::text[]) AS r(urn) JOIN urn_mappings u ON u.interchange_uuid = $1 AND u.urn = r.urn
+`
+
+// GetMappedURNs returns which of the passed in URNs are mapped to a channel of the passed in interchange
+func GetMappedURNs(ctx context.Context, db *sqlx.DB, interchange *Interchange, urns []string) (map[string]bool, error) {
+	found := make([]string, 0)
+	err := db.SelectContext(ctx, &found, selectMappedURNsSQL, interchange.UUID, pq.Array(urns))
+	if err != nil {
+		slog.Error("error selecting mapped urns", "error", err)
+		return nil, err
+	}
+
+	mapped := make(map[string]bool, len(found))
+	for _, urn := range found {
+		mapped[urn] = true
+	}
+	return mapped, nil
 }
 
 var (
+	likeEscaper      = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 	validate         = validator.New()
 	interchangeCache = map[string]*Interchange{}
 	cacheLock        = sync.RWMutex{}
@@ -302,6 +557,47 @@ func mapKeys(m map[string]bool) []string {
 	return keys
 }
 
+// prepareInterchangeConfig validates the passed in interchanges and fills in the fields derived from their config,
+// ready for them to be saved
+func prepareInterchangeConfig(interchanges []*Interchange) error {
+	// UUIDs are always stored lowercase, as postgres returns them
+	for _, interchange := range interchanges {
+		interchange.UUID = strings.ToLower(interchange.UUID)
+		for c := range interchange.Channels {
+			interchange.Channels[c].UUID = strings.ToLower(interchange.Channels[c].UUID)
+			interchange.Channels[c].InterchangeUUID = interchange.UUID
+			if forwarding := interchange.Channels[c].Forwarding; forwarding != nil {
+				forwarding.Failover = strings.ToLower(forwarding.Failover)
+			}
+			if whenFull := interchange.Channels[c].WhenFull; whenFull != nil {
+				whenFull.Overflow = strings.ToLower(whenFull.Overflow)
+			}
+		}
+		for _, schedule := range interchange.Schedules {
+			schedule.Channel = strings.ToLower(schedule.Channel)
+		}
+		for r := range interchange.PrefixRules {
+			rule := &interchange.PrefixRules[r]
+			rule.Channel = strings.ToLower(rule.Channel)
+			if rule.Prefix != "" {
+				rule.Prefix = "+" + strings.TrimLeft(strings.TrimSpace(rule.Prefix), "+")
+			}
+		}
+	}
+
+	err := validateInterchangeConfig(interchanges)
+	if err != nil {
+		return err
+	}
+
+	for _, interchange := range interchanges {
+		// set our default channel UUID to the first channel
+		interchange.DefaultChannelUUID = interchange.Channels[0].UUID
+	}
+
+	return nil
+}
+
 func validateInterchangeConfig(interchanges []*Interchange) error {
 	// validate our interchanges as a whole
 	seenInterchanges := make(map[string]bool)
@@ -320,6 +616,57 @@ func validateInterchangeConfig(interchanges []*Interchange) error {
 		}
 		seenInterchanges[interchange.UUID] = true
 
+		if interchange.Auth != nil {
+			err = validateObject(interchange.Auth)
+			if err != nil {
+				return err
+			}
+			err = interchange.Auth.check()
+			if err != nil {
+				return fmt.Errorf("invalid auth for interchange %s: %w", interchange.UUID, err)
+			}
+		}
+
+		for _, allowed := range interchange.AllowedIPs {
+			_, err = ParseNetwork(allowed)
+			if err != nil {
+				return fmt.Errorf("invalid allowed IPs for interchange %s: %w", interchange.UUID, err)
+			}
+		}
+
+		if interchange.RateLimit != nil {
+			err = interchange.RateLimit.check()
+			if err != nil {
+				return fmt.Errorf("invalid rate limit for interchange %s: %w", interchange.UUID, err)
+			}
+		}
+
+		if interchange.Dedup != nil {
+			err = validateObject(interchange.Dedup)
+			if err != nil {
+				return fmt.Errorf("invalid dedup for interchange %s: %w", interchange.UUID, err)
+			}
+		}
+
+		if interchange.Timezone != "" {
+			err = checkTimezone(interchange.Timezone)
+			if err != nil {
+				return fmt.Errorf("invalid timezone for interchange %s: %w", interchange.UUID, err)
+			}
+		}
+
+		for _, schedule := range interchange.Schedules {
+			err = schedule.check(interchange)
+			if err != nil {
+				return fmt.Errorf("invalid schedule for interchange %s: %w", interchange.UUID, err)
+			}
+		}
+
+		err = interchange.PrefixRules.check(interchange)
+		if err != nil {
+			return fmt.Errorf("invalid prefix rules for interchange %s: %w", interchange.UUID, err)
+		}
+
 		for _, channel := range interchange.Channels {
 			err = validateObject(channel)
 			if err != nil {
@@ -331,6 +678,42 @@ func validateInterchangeConfig(interchanges []*Interchange) error {
 			}
 			seenChannels[channel.UUID] = true
 
+			if channel.Auth != nil {
+				err = validateObject(channel.Auth)
+				if err != nil {
+					return err
+				}
+				err = channel.Auth.check()
+				if err != nil {
+					return fmt.Errorf("invalid auth for channel %s: %w", channel.UUID, err)
+				}
+			}
+
+			if channel.Forwarding != nil {
+				err = channel.Forwarding.check(interchange, &channel)
+				if err != nil {
+					return fmt.Errorf("invalid forwarding for channel %s: %w", channel.UUID, err)
+				}
+			}
+
+			if interchange.Distribution == DistributionWeighted && channel.Weight < 1 {
+				return fmt.Errorf("channel %s needs a weight of at least 1 for weighted distribution", channel.UUID)
+			}
+
+			if channel.HealthCheck != nil {
+				err = validateObject(channel.HealthCheck)
+				if err != nil {
+					return fmt.Errorf("invalid health check for channel %s: %w", channel.UUID, err)
+				}
+			}
+
+			if channel.WhenFull != nil {
+				err = channel.WhenFull.check(interchange, &channel)
+				if err != nil {
+					return fmt.Errorf("invalid when_full for channel %s: %w", channel.UUID, err)
+				}
+			}
+
 			for i, keyword := range channel.Keywords {
 				keyword = strings.ToLower(keyword)
 				if seenKeywords[keyword] {
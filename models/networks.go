@@ -0,0 +1,59 @@
+package models
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseNetwork parses the passed in CIDR, or single IP address which is treated as a network of just that address
+func ParseNetwork(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid network '%s'", s)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network '%s'", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// NetworksContain returns whether the passed in IP address is in any of the passed in networks
+func NetworksContain(networks []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsIP returns whether the passed in IP address may send requests to this interchange, interchanges without an
+// allowlist allow any address
+func (i *Interchange) AllowsIP(ip string) bool {
+	if len(i.AllowedIPs) == 0 {
+		return true
+	}
+
+	networks := make([]netip.Prefix, 0, len(i.AllowedIPs))
+	for _, allowed := range i.AllowedIPs {
+		// our allowlist is validated when saved, so anything unparseable just doesn't match
+		if network, err := ParseNetwork(allowed); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return NetworksContain(networks, ip)
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// PrefixRule routes senders whose URN path starts with a prefix, such as an operator's number range, to a channel.
+// Prefixes always start with a + like the URN paths of our senders, and one is added if it is missing.
+type PrefixRule struct {
+	Prefix  string `json:"prefix"  validate:"required"`
+	Channel string `json:"channel" validate:"required,uuid4"`
+}
+
+// PrefixRules are the prefix rules of an interchange in the order they are evaluated, stored as a JSON list
+type PrefixRules []PrefixRule
+
+// Value returns our prefix rules as JSON for storing in the db
+func (p PrefixRules) Value() (driver.Value, error) {
+	return jsonColumn[PrefixRules]{&p, "prefix rules"}.Value()
+}
+
+// Scan reads our prefix rules from the JSON stored in the db
+func (p *PrefixRules) Scan(value any) error {
+	return jsonColumn[PrefixRules]{p, "prefix rules"}.Scan(value)
+}
+
+// MatchPrefixRule returns the first of the prefix rules of this interchange which matches the passed in URN, if any
+func (i *Interchange) MatchPrefixRule(urn string) *PrefixRule {
+	_, path, _ := strings.Cut(urn, ":")
+	for r := range i.PrefixRules {
+		if strings.HasPrefix(path, i.PrefixRules[r].Prefix) {
+			return &i.PrefixRules[r]
+		}
+	}
+	return nil
+}
+
+// check makes sure our rules route to channels in the passed in interchange, and that no two rules overlap so that
+// the order of our rules never hides one of them
+func (p PrefixRules) check(interchange *Interchange) error {
+	for i, rule := range p {
+		if err := validateObject(rule); err != nil {
+			return err
+		}
+		if rule.Prefix == "+" {
+			return fmt.Errorf("prefix can't be empty")
+		}
+		if interchange.GetChannel(rule.Channel) == nil {
+			return fmt.Errorf("prefix channel %s is not in this interchange", rule.Channel)
+		}
+		for _, other := range p[:i] {
+			if strings.HasPrefix(rule.Prefix, other.Prefix) || strings.HasPrefix(other.Prefix, rule.Prefix) {
+				return fmt.Errorf("prefixes %s and %s overlap", other.Prefix, rule.Prefix)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// the actions we can take when a request is over its rate limit
+const (
+	RateLimitReject = "reject"
+	RateLimitDrop   = "drop"
+)
+
+// RateLimit is the rate limiting configured on an interchange, there can be a limit per URN, for the interchange as
+// a whole or both. Requests over a limit are rejected with a 429 unless our action is drop, in which case they are
+// silently accepted without being forwarded.
+type RateLimit struct {
+	URN         *Bucket `json:"urn,omitempty"`
+	Interchange *Bucket `json:"interchange,omitempty"`
+	Action      string  `json:"action,omitempty"      validate:"omitempty,oneof=reject drop"`
+}
+
+// Bucket is a token bucket which is refilled at rate tokens per second and can hold at most burst tokens, each
+// request takes a token and requests are limited when it is empty
+type Bucket struct {
+	Rate  float64 `json:"rate"  validate:"gt=0"`
+	Burst int     `json:"burst" validate:"gte=1"`
+}
+
+// Value returns our rate limit as JSON for storing in the db
+func (l *RateLimit) Value() (driver.Value, error) {
+	return jsonColumn[RateLimit]{l, "rate limit"}.Value()
+}
+
+// Scan reads our rate limit from the JSON stored in the db
+func (l *RateLimit) Scan(value any) error {
+	return jsonColumn[RateLimit]{l, "rate limit"}.Scan(value)
+}
+
+// check makes sure our rate limit is valid
+func (l *RateLimit) check() error {
+	if l.URN == nil && l.Interchange == nil {
+		return fmt.Errorf("rate limit must limit URNs, the interchange or both")
+	}
+	for _, bucket := range []*Bucket{l.URN, l.Interchange} {
+		if bucket != nil {
+			if err := validateObject(bucket); err != nil {
+				return err
+			}
+		}
+	}
+	return validateObject(l)
+}
+
+// refill returns how many tokens a bucket which had the passed in tokens has after the passed in number of seconds
+func (b *Bucket) refill(tokens float64, elapsed float64) float64 {
+	return math.Min(float64(b.Burst), tokens+math.Max(elapsed, 0)*b.Rate)
+}
+
+// RateLimiter keeps the state of our rate limit buckets
+type RateLimiter interface {
+	// TakeToken takes a token from the bucket with the passed in key, returning whether there was one to take
+	TakeToken(ctx context.Context, key string, bucket *Bucket) (bool, error)
+
+	// PruneTokens removes the state of any buckets which have refilled, as they are the same as new buckets
+	PruneTokens(ctx context.Context) error
+}
+
+// takeTokenSQL takes a token from a bucket in a single statement so that concurrent requests on different instances
+// can't both take the last token. New buckets start full. All expressions in the update see the row as it was before.
+const takeTokenSQL = `
+INSERT INTO rate_limits (key, tokens, allowed, updated_on, full_on)
+VALUES ($1, $3::float8 - 1, TRUE, NOW(), NOW() + INTERVAL '1 second' / $2::float8)
+ON CONFLICT (key)
+DO
+ UPDATE
+   SET allowed = (%[1]s) >= 1,
+       tokens = CASE WHEN (%[1]s) >= 1 THEN (%[1]s) - 1 ELSE (%[1]s) END,
+       updated_on = NOW(),
+       full_on = NOW() + ($3::float8 - CASE WHEN (%[1]s) >= 1 THEN (%[1]s) - 1 ELSE (%[1]s) END) / $2::float8 * INTERVAL '1 second'
+RETURNING allowed
+`
+
+// the tokens in a bucket once it has been refilled for the time since it was last updated
+const availableTokensSQL = `LEAST($3::float8, rate_limits.tokens + GREATEST(EXTRACT(EPOCH FROM NOW() - rate_limits.updated_on)::float8, 0) * $2::float8)`
+
+// TakeRateLimitToken takes a token from the bucket with the passed in key, returning whether there was one to take
+func TakeRateLimitToken(ctx context.Context, db *sqlx.DB, key string, bucket *Bucket) (bool, error) {
+	var allowed bool
+	err := db.GetContext(ctx, &allowed, fmt.Sprintf(takeTokenSQL, availableTokensSQL), key, bucket.Rate, float64(bucket.Burst))
+	if err != nil {
+		slog.Error("error taking rate limit token", "error", err, "key", key)
+		return false, err
+	}
+	return allowed, nil
+}
+
+// PruneRateLimits removes the state of any buckets which have refilled
+func PruneRateLimits(ctx context.Context, db *sqlx.DB) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_on < NOW()`)
+	return err
+}
+
+// MemoryRateLimiter keeps our rate limit buckets in memory, so limits only apply per instance
+type MemoryRateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucketState
+}
+
+type bucketState struct {
+	tokens    float64
+	updatedOn time.Time
+	fullOn    time.Time
+}
+
+var _ RateLimiter = (*MemoryRateLimiter)(nil)
+
+// NewMemoryRateLimiter creates a new rate limiter with all its buckets full
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: make(map[string]*bucketState)}
+}
+
+// TakeToken takes a token from the bucket with the passed in key, returning whether there was one to take
+func (l *MemoryRateLimiter) TakeToken(ctx context.Context, key string, bucket *Bucket) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	state, found := l.buckets[key]
+	if !found {
+		state = &bucketState{tokens: float64(bucket.Burst), updatedOn: now}
+		l.buckets[key] = state
+	}
+
+	state.tokens = bucket.refill(state.tokens, now.Sub(state.updatedOn).Seconds())
+	state.updatedOn = now
+
+	allowed := state.tokens >= 1
+	if allowed {
+		state.tokens--
+	}
+	state.fullOn = now.Add(time.Duration((float64(bucket.Burst) - state.tokens) / bucket.Rate * float64(time.Second)))
+
+	return allowed, nil
+}
+
+// PruneTokens removes the state of any buckets which have refilled
+func (l *MemoryRateLimiter) PruneTokens(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	for key, state := range l.buckets {
+		if state.fullOn.Before(now) {
+			delete(l.buckets, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the senders a schedule applies to outside of its hours
+const (
+	ScheduleScopeAll      = "all"
+	ScheduleScopeUnmapped = "unmapped"
+)
+
+// the days of the week schedule windows can be open on, indexed by time.Weekday
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule is when the channels of an interchange are staffed, evaluated in the timezone of the interchange. Outside
+// of its windows, either all messages or only those from unmapped senders are sent to its channel instead, except for
+// keyword joins which always go to the channel joined. Exceptions replace the windows on their date, closing the whole
+// day unless they give the hours they are open.
+type Schedule struct {
+	Name       string               `json:"name"                 validate:"required"`
+	Channel    string               `json:"channel"              validate:"required,uuid4"`
+	Scope      string               `json:"scope,omitempty"      validate:"omitempty,oneof=all unmapped"`
+	Windows    []*ScheduleWindow    `json:"windows"              validate:"dive"`
+	Exceptions []*ScheduleException `json:"exceptions,omitempty" validate:"dive"`
+}
+
+// ScheduleWindow is the hours a schedule is open on the passed in days, times are HH:MM and the end can be 24:00
+type ScheduleWindow struct {
+	Days  []string `json:"days"  validate:"required,dive,oneof=mon tue wed thu fri sat sun"`
+	Start string   `json:"start" validate:"required"`
+	End   string   `json:"end"   validate:"required"`
+}
+
+// ScheduleException is a date, such as a holiday, when a schedule isn't open during its usual windows
+type ScheduleException struct {
+	Date  string `json:"date"            validate:"required,datetime=2006-01-02"`
+	Start string `json:"start,omitempty" validate:"required_with=End"`
+	End   string `json:"end,omitempty"   validate:"required_with=Start"`
+}
+
+// Schedules are the schedules of an interchange, stored as a JSON list
+type Schedules []*Schedule
+
+// Value returns our schedules as JSON for storing in the db
+func (s Schedules) Value() (driver.Value, error) {
+	return jsonColumn[Schedules]{&s, "schedules"}.Value()
+}
+
+// Scan reads our schedules from the JSON stored in the db
+func (s *Schedules) Scan(value any) error {
+	return jsonColumn[Schedules]{s, "schedules"}.Scan(value)
+}
+
+// clone returns a deep copy of our schedules
+func (s Schedules) clone() Schedules {
+	if s == nil {
+		return nil
+	}
+	c := make(Schedules, len(s))
+	for i, schedule := range s {
+		sc := *schedule
+		sc.Windows = make([]*ScheduleWindow, len(schedule.Windows))
+		for w, window := range schedule.Windows {
+			wc := *window
+			wc.Days = append([]string(nil), window.Days...)
+			sc.Windows[w] = &wc
+		}
+		if schedule.Exceptions != nil {
+			sc.Exceptions = make([]*ScheduleException, len(schedule.Exceptions))
+			for e, exception := range schedule.Exceptions {
+				ec := *exception
+				sc.Exceptions[e] = &ec
+			}
+		}
+		c[i] = &sc
+	}
+	return c
+}
+
+// IsOpen returns whether this schedule is open at the passed in time, which should be in the timezone of its interchange
+func (s *Schedule) IsOpen(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+
+	date := t.Format("2006-01-02")
+	for _, exception := range s.Exceptions {
+		if exception.Date == date {
+			if exception.Start == "" {
+				return false
+			}
+			return inHours(exception.Start, exception.End, minute)
+		}
+	}
+
+	day := scheduleDays[t.Weekday()]
+	for _, window := range s.Windows {
+		for _, d := range window.Days {
+			if d == day && inHours(window.Start, window.End, minute) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AppliesTo returns whether this schedule applies to a sender, who is unmapped if they weren't routed by an existing
+// mapping or a prefix rule
+func (s *Schedule) AppliesTo(unmapped bool) bool {
+	return unmapped || s.Scope == "" || s.Scope == ScheduleScopeAll
+}
+
+// ClosedSchedule returns the first of the schedules of this interchange which is closed at the passed in time and
+// applies to the sender, if any
+func (i *Interchange) ClosedSchedule(now time.Time, unmapped bool) *Schedule {
+	if len(i.Schedules) == 0 {
+		return nil
+	}
+
+	local := now.In(loadLocation(i.Timezone))
+	for _, schedule := range i.Schedules {
+		if schedule.AppliesTo(unmapped) && !schedule.IsOpen(local) {
+			return schedule
+		}
+	}
+	return nil
+}
+
+// check makes sure the hours of our windows and exceptions are valid, and our channel is in the passed in interchange
+func (s *Schedule) check(interchange *Interchange) error {
+	if err := validateObject(s); err != nil {
+		return err
+	}
+	for _, window := range s.Windows {
+		if err := checkHours(window.Start, window.End); err != nil {
+			return err
+		}
+	}
+	seenDates := make(map[string]bool)
+	for _, exception := range s.Exceptions {
+		if seenDates[exception.Date] {
+			return fmt.Errorf("duplicate exception date: %s", exception.Date)
+		}
+		seenDates[exception.Date] = true
+
+		if exception.Start != "" {
+			if err := checkHours(exception.Start, exception.End); err != nil {
+				return err
+			}
+		}
+	}
+	if interchange.GetChannel(s.Channel) == nil {
+		return fmt.Errorf("schedule channel %s is not in this interchange", s.Channel)
+	}
+	return nil
+}
+
+// inHours returns whether the passed in minute of the day is within the passed in hours, which have been checked
+func inHours(start string, end string, minute int) bool {
+	s, _ := parseClock(start)
+	e, _ := parseClock(end)
+	return minute >= s && minute < e
+}
+
+func checkHours(start string, end string) error {
+	s, err := parseClock(start)
+	if err != nil {
+		return err
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return err
+	}
+	if e <= s {
+		return fmt.Errorf("hours must end after they start, got %s-%s", start, end)
+	}
+	return nil
+}
+
+// parseClock parses a HH:MM time of day into the minute of the day, allowing 24:00 for the end of the day
+func parseClock(clock string) (int, error) {
+	if clock == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil || len(clock) != 5 {
+		return 0, fmt.Errorf("invalid time '%s', must be HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+var locations sync.Map
+
+// loadLocation returns the location for the passed in timezone, which has been validated, caching it as loading
+// locations reads the timezone database
+func loadLocation(timezone string) *time.Location {
+	if loc, found := locations.Load(timezone); found {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	locations.Store(timezone, loc)
+	return loc
+}
+
+// checkTimezone makes sure the passed in timezone is one we can load, timezones are case sensitive
+func checkTimezone(timezone string) error {
+	if strings.EqualFold(timezone, "local") {
+		return fmt.Errorf("unknown timezone '%s'", timezone)
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("unknown timezone '%s'", timezone)
+	}
+	return nil
+}
@@ -0,0 +1,778 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/rp-clover/migrations"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	sqlx.BindDriver("sqlite", sqlx.QUESTION)
+}
+
+// the options we open every sqlite database with. We enforce foreign keys so deletes cascade, use WAL so exports
+// don't block writes, wait for locks rather than failing and write times in a format sqlite's date functions
+// understand.
+const sqliteOptions = "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_time_format=sqlite&_txlock=immediate"
+
+// SQLiteStore is a store backed by an embedded sqlite database, for small deployments with a single instance
+type SQLiteStore struct {
+	db *sqlx.DB
+}
+
+// OpenSQLiteStore opens the sqlite database at the passed in path, creating it if necessary, and migrates it forward
+func OpenSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	db, err := openSQLiteDB(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrations.Migrate(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func openSQLiteDB(ctx context.Context, path string) (*sqlx.DB, error) {
+	dsn := "file:" + path
+	if strings.Contains(path, "?") {
+		dsn += "&" + sqliteOptions
+	} else {
+		dsn += "?" + sqliteOptions
+	}
+
+	db, err := sqlx.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(4)
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		slog.Error("unable to open sqlite database", "error", err)
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// DB returns the database for this store
+func (s *SQLiteStore) DB() *sqlx.DB {
+	return s.db
+}
+
+// UpdateInterchangeConfig replaces our interchange config with the passed in interchanges
+func (s *SQLiteStore) UpdateInterchangeConfig(ctx context.Context, interchanges []*Interchange) (err error) {
+	err = prepareInterchangeConfig(interchanges)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// this will either rollback or commit based on our error state
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	interchangeUUIDs := make([]string, 0, len(interchanges))
+	channelUUIDs := make([]string, 0, len(interchanges))
+	for _, interchange := range interchanges {
+		interchangeUUIDs = append(interchangeUUIDs, interchange.UUID)
+
+		_, err = tx.NamedExecContext(ctx, upsertInterchangeSQL, interchange)
+		if err != nil {
+			slog.Error("error upserting interchange", "error", err)
+			return err
+		}
+
+		for _, channel := range interchange.Channels {
+			channelUUIDs = append(channelUUIDs, channel.UUID)
+
+			_, err = tx.NamedExecContext(ctx, upsertChannelSQL, channel)
+			if err != nil {
+				slog.Error("error upserting channel", "error", err)
+				return err
+			}
+		}
+	}
+
+	// remove all the interchanges and channels we didn't see, deletes cascade to channels and mappings
+	if len(interchanges) == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM interchanges`)
+		return err
+	}
+
+	err = execIn(ctx, tx, `DELETE FROM interchanges WHERE uuid NOT IN (?)`, interchangeUUIDs)
+	if err != nil {
+		return err
+	}
+
+	return execIn(ctx, tx, `DELETE FROM channels WHERE uuid NOT IN (?)`, channelUUIDs)
+}
+
+// execIn executes the passed in query with its single ? expanded to the passed in values
+func execIn(ctx context.Context, tx *sqlx.Tx, query string, values []string) error {
+	query, args, err := sqlx.In(query, values)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetInterchangeConfig returns our complete interchange config
+func (s *SQLiteStore) GetInterchangeConfig(ctx context.Context) ([]*Interchange, error) {
+	interchanges := make([]*Interchange, 0, 5)
+	err := s.db.SelectContext(ctx, &interchanges, `SELECT * FROM interchanges ORDER BY uuid`)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range interchanges {
+		interchanges[i].Channels, err = s.getChannelsForInterchange(ctx, interchanges[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return interchanges, nil
+}
+
+// GetInterchange returns the interchange with the passed in UUID
+func (s *SQLiteStore) GetInterchange(ctx context.Context, uuid string) (*Interchange, error) {
+	interchange := &Interchange{}
+	err := s.db.GetContext(ctx, interchange, `SELECT * FROM interchanges WHERE uuid = ?`, strings.ToLower(uuid))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		slog.Error("error looking up interchange", "error", err)
+		return nil, err
+	}
+
+	interchange.Channels, err = s.getChannelsForInterchange(ctx, interchange)
+	if err != nil {
+		slog.Error("error looking up channels for interchange", "error", err)
+		return nil, err
+	}
+
+	return interchange, nil
+}
+
+func (s *SQLiteStore) getChannelsForInterchange(ctx context.Context, interchange *Interchange) ([]Channel, error) {
+	channels := []Channel{}
+	err := s.db.SelectContext(ctx, &channels, `SELECT * FROM channels WHERE interchange_uuid = ? ORDER BY uuid`, interchange.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = defaultChannelFirst(interchange, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+const sqliteUpsertURNMappingSQL = `
+INSERT INTO urn_mappings (interchange_uuid, channel_uuid, urn, created_on, modified_on)
+VALUES (?1, ?2, ?3, ?4, ?4)
+ON CONFLICT (urn, interchange_uuid)
+DO
+ UPDATE
+   SET channel_uuid = ?2, modified_on = ?4
+`
+
+// SetChannelForURN maps the passed in URN to the passed in channel
+func (s *SQLiteStore) SetChannelForURN(ctx context.Context, interchange *Interchange, channel *Channel, urn string) error {
+	// double check our channel membership
+	if channel.InterchangeUUID != interchange.UUID {
+		return fmt.Errorf("channel does not belong to interchange %s != %s", channel.InterchangeUUID, interchange.UUID)
+	}
+
+	_, err := s.db.ExecContext(ctx, sqliteUpsertURNMappingSQL, interchange.UUID, channel.UUID, urn, time.Now().UTC())
+	if err != nil {
+		slog.Error("error upserting urn mapping", "error", err)
+	}
+	return err
+}
+
+// AssignChannelForURN maps the passed in URN to the passed in channel unless it has reached its capacity. Our
+// transactions take the write lock when they begin, so concurrent assignments can't take a channel over its capacity.
+func (s *SQLiteStore) AssignChannelForURN(ctx context.Context, interchange *Interchange, channel *Channel, urn string) (assigned bool, err error) {
+	// double check our channel membership
+	if channel.InterchangeUUID != interchange.UUID {
+		return false, fmt.Errorf("channel does not belong to interchange %s != %s", channel.InterchangeUUID, interchange.UUID)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// this will either rollback or commit based on whether we assigned the channel
+	defer func() {
+		if err != nil || !assigned {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			assigned = false
+		}
+	}()
+
+	if channel.Capacity > 0 {
+		count := 0
+		err = tx.GetContext(ctx, &count, `SELECT COUNT(*) FROM urn_mappings WHERE interchange_uuid = ? AND channel_uuid = ?`, interchange.UUID, channel.UUID)
+		if err != nil {
+			return false, err
+		}
+		if count >= channel.Capacity {
+			return false, nil
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, sqliteUpsertURNMappingSQL, interchange.UUID, channel.UUID, urn, time.Now().UTC())
+	if err != nil {
+		slog.Error("error upserting urn mapping", "error", err)
+		return false, err
+	}
+	return true, nil
+}
+
+const sqliteGetChannelForURNSQL = `
+SELECT c.*
+FROM urn_mappings u, channels c
+WHERE u.interchange_uuid = ? AND u.urn = ? AND u.channel_uuid = c.uuid
+`
+
+// GetChannelForURN returns the channel the passed in URN is mapped to
+func (s *SQLiteStore) GetChannelForURN(ctx context.Context, interchange *Interchange, urn string) (*Channel, error) {
+	channel := &Channel{}
+	err := s.db.GetContext(ctx, channel, sqliteGetChannelForURNSQL, interchange.UUID, urn)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// ClearChannelForURN removes any mapping for the passed in URN
+func (s *SQLiteStore) ClearChannelForURN(ctx context.Context, interchange *Interchange, urn string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM urn_mappings WHERE interchange_uuid = ? AND urn = ?`, interchange.UUID, urn)
+	if err != nil {
+		slog.Error("error deleting urn mapping", "error", err)
+	}
+	return err
+}
+
+// GetURNMapping returns the mapping for the passed in URN
+func (s *SQLiteStore) GetURNMapping(ctx context.Context, interchange *Interchange, urn string) (*URNMapping, error) {
+	mapping := &URNMapping{}
+	err := s.db.GetContext(ctx, mapping, `SELECT * FROM urn_mappings WHERE interchange_uuid = ? AND urn = ?`, interchange.UUID, urn)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return mapping, err
+}
+
+// sqlite's LIKE is case insensitive, so we match prefixes with instr which is 1 for an empty prefix
+const sqliteListURNMappingsSQL = `
+SELECT urn, interchange_uuid, channel_uuid, created_on, modified_on
+FROM urn_mappings
+WHERE
+  interchange_uuid = ?1 AND
+  instr(urn, ?2) = 1 AND
+  (?3 = '' OR channel_uuid = ?3) AND
+  urn > ?4
+ORDER BY urn
+LIMIT ?5
+`
+
+// ListURNMappings returns a page of the mappings for the passed in interchange
+func (s *SQLiteStore) ListURNMappings(ctx context.Context, interchange *Interchange, query *URNMappingQuery) ([]*URNMapping, error) {
+	mappings := make([]*URNMapping, 0, query.Limit)
+	err := s.db.SelectContext(ctx, &mappings, sqliteListURNMappingsSQL, interchange.UUID, query.Prefix, query.ChannelUUID, query.After, query.Limit)
+	if err != nil {
+		slog.Error("error listing urn mappings", "error", err)
+		return nil, err
+	}
+	return mappings, nil
+}
+
+const sqliteCountURNMappingsSQL = `
+SELECT c.uuid as channel_uuid, COUNT(u.urn) as count
+FROM channels c LEFT OUTER JOIN urn_mappings u ON u.channel_uuid = c.uuid
+WHERE c.interchange_uuid = ?
+GROUP BY c.uuid
+ORDER BY c.uuid
+`
+
+// CountURNMappings returns the number of URNs mapped to each channel of the passed in interchange
+func (s *SQLiteStore) CountURNMappings(ctx context.Context, interchange *Interchange) ([]*ChannelCount, error) {
+	counts := make([]*ChannelCount, 0, len(interchange.Channels))
+	err := s.db.SelectContext(ctx, &counts, sqliteCountURNMappingsSQL, interchange.UUID)
+	if err != nil {
+		slog.Error("error counting urn mappings", "error", err)
+		return nil, err
+	}
+	return counts, nil
+}
+
+const sqliteSelectMappedURNsSQL = `
+SELECT u.urn
+FROM json_each(?2) AS r JOIN urn_mappings u ON u.interchange_uuid = ?1 AND u.urn = r.value
+`
+
+// GetMappedURNs returns which of the passed in URNs are mapped to a channel of the passed in interchange
+func (s *SQLiteStore) GetMappedURNs(ctx context.Context, interchange *Interchange, urns []string) (map[string]bool, error) {
+	encoded, err := json.Marshal(urns)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]string, 0)
+	err = s.db.SelectContext(ctx, &found, sqliteSelectMappedURNsSQL, interchange.UUID, string(encoded))
+	if err != nil {
+		slog.Error("error selecting mapped urns", "error", err)
+		return nil, err
+	}
+
+	mapped := make(map[string]bool, len(found))
+	for _, urn := range found {
+		mapped[urn] = true
+	}
+	return mapped, nil
+}
+
+const sqliteExportURNMappingsSQL = `
+SELECT urn, interchange_uuid, channel_uuid, created_on, modified_on
+FROM urn_mappings
+WHERE interchange_uuid = ?1 AND (?2 = '' OR channel_uuid = ?2)
+ORDER BY urn
+`
+
+// ExportURNMappings calls fn for every mapping of the passed in interchange, rows are read as they are exported
+func (s *SQLiteStore) ExportURNMappings(ctx context.Context, interchangeUUID string, channelUUID string, fn func(*URNMapping) error) error {
+	rows, err := s.db.QueryxContext(ctx, sqliteExportURNMappingsSQL, strings.ToLower(interchangeUUID), channelUUID)
+	if err != nil {
+		slog.Error("error exporting urn mappings", "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		mapping := &URNMapping{}
+		err = rows.StructScan(mapping)
+		if err != nil {
+			return err
+		}
+
+		err = fn(mapping)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+const sqliteUpsertUserSQL = `
+INSERT INTO users (username, password_hash, role, created_on, modified_on)
+VALUES (:username, :password_hash, :role, :created_on, :modified_on)
+ON CONFLICT (username)
+DO
+ UPDATE
+   SET password_hash = :password_hash, role = :role, modified_on = :modified_on
+`
+
+const sqliteUpdateUserRoleSQL = `
+UPDATE users
+   SET role = :role, modified_on = :modified_on
+ WHERE username = :username
+`
+
+// SaveUser creates or updates the user with the passed in username, an empty password updates only their role
+func (s *SQLiteStore) SaveUser(ctx context.Context, username string, password string, role Role) error {
+	if password == "" {
+		user, err := newRoleUpdate(username, role)
+		if err != nil {
+			return err
+		}
+		user.ModifiedOn = time.Now().UTC()
+
+		result, err := s.db.NamedExecContext(ctx, sqliteUpdateUserRoleSQL, user)
+		if err != nil {
+			slog.Error("error updating user role", "error", err)
+			return err
+		}
+		return checkRoleUpdated(result)
+	}
+
+	user, err := newUser(username, password, role)
+	if err != nil {
+		return err
+	}
+	user.CreatedOn = time.Now().UTC()
+	user.ModifiedOn = user.CreatedOn
+
+	_, err = s.db.NamedExecContext(ctx, sqliteUpsertUserSQL, user)
+	if err != nil {
+		slog.Error("error upserting user", "error", err)
+	}
+	return err
+}
+
+// GetUser returns the user with the passed in username
+func (s *SQLiteStore) GetUser(ctx context.Context, username string) (*User, error) {
+	user := &User{}
+	err := s.db.GetContext(ctx, user, `SELECT * FROM users WHERE username = ?`, username)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// GetUsers returns all our users ordered by username
+func (s *SQLiteStore) GetUsers(ctx context.Context) ([]*User, error) {
+	users := make([]*User, 0, 5)
+	err := s.db.SelectContext(ctx, &users, `SELECT * FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteUser deletes the user with the passed in username
+func (s *SQLiteStore) DeleteUser(ctx context.Context, username string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE username = ?`, username)
+	if err != nil {
+		slog.Error("error deleting user", "error", err)
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// AuthenticateUser returns the user with the passed in username if the password matches
+func (s *SQLiteStore) AuthenticateUser(ctx context.Context, username string, password string) (*User, error) {
+	user, err := s.GetUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.checkPassword(password) {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+const sqliteInsertAPITokenSQL = `
+INSERT INTO api_tokens (name, token_hash, permissions, interchange_uuids, created_by, created_on)
+VALUES (:name, :token_hash, :permissions, :interchange_uuids, :created_by, :created_on)
+`
+
+// CreateAPIToken creates a new API token
+func (s *SQLiteStore) CreateAPIToken(ctx context.Context, name string, permissions []Permission, interchangeUUIDs []string, createdBy string) (*APIToken, string, error) {
+	token, value, err := newAPIToken(name, permissions, interchangeUUIDs, createdBy)
+	if err != nil {
+		return nil, "", err
+	}
+	token.CreatedOn = time.Now().UTC()
+
+	result, err := s.db.NamedExecContext(ctx, sqliteInsertAPITokenSQL, token)
+	if err != nil {
+		slog.Error("error inserting api token", "error", err)
+		return nil, "", err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token = &APIToken{}
+	err = s.db.GetContext(ctx, token, `SELECT * FROM api_tokens WHERE id = ?`, id)
+	return token, value, err
+}
+
+// GetAPITokens returns all our API tokens ordered by id
+func (s *SQLiteStore) GetAPITokens(ctx context.Context) ([]*APIToken, error) {
+	tokens := make([]*APIToken, 0, 5)
+	err := s.db.SelectContext(ctx, &tokens, `SELECT * FROM api_tokens ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetAPIToken returns the token with the passed in id
+func (s *SQLiteStore) GetAPIToken(ctx context.Context, id int) (*APIToken, error) {
+	token := &APIToken{}
+	err := s.db.GetContext(ctx, token, `SELECT * FROM api_tokens WHERE id = ?`, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// RevokeAPIToken revokes the token with the passed in id
+func (s *SQLiteStore) RevokeAPIToken(ctx context.Context, id int) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `UPDATE api_tokens SET revoked_on = ? WHERE id = ? AND revoked_on IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		slog.Error("error revoking api token", "error", err)
+		return false, err
+	}
+
+	revoked, err := result.RowsAffected()
+	return revoked > 0, err
+}
+
+const sqliteUseAPITokenSQL = `
+UPDATE api_tokens
+   SET last_used_on = ?
+ WHERE token_hash = ? AND revoked_on IS NULL
+RETURNING *
+`
+
+// AuthenticateAPIToken returns the active token matching the passed in value
+func (s *SQLiteStore) AuthenticateAPIToken(ctx context.Context, value string) (*APIToken, error) {
+	token := &APIToken{}
+	err := s.db.GetContext(ctx, token, sqliteUseAPITokenSQL, time.Now().UTC(), hashToken(value))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		slog.Error("error authenticating api token", "error", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+const sqliteInsertAuditEntrySQL = `
+INSERT INTO audit_log (created_on, actor, action, target, before, after, source_ip, request_id)
+VALUES (:created_on, :actor, :action, :target, :before, :after, :source_ip, :request_id)
+`
+
+// InsertAuditEntry appends the passed in entry to our audit log
+func (s *SQLiteStore) InsertAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	e := *entry
+	e.CreatedOn = time.Now().UTC()
+
+	_, err := s.db.NamedExecContext(ctx, sqliteInsertAuditEntrySQL, &e)
+	if err != nil {
+		slog.Error("error inserting audit entry", "error", err)
+	}
+	return err
+}
+
+const sqliteSelectAuditEntriesSQL = `
+SELECT id, created_on, actor, action, target, before, after, source_ip, request_id
+FROM audit_log
+WHERE
+  (?1 = '' OR actor = ?1) AND
+  (?2 = '' OR action = ?2) AND
+  instr(target, ?3) = 1 AND
+  (?4 IS NULL OR julianday(created_on) >= julianday(?4)) AND
+  (?5 IS NULL OR julianday(created_on) < julianday(?5)) AND
+  (?6 = 0 OR id < ?6)
+ORDER BY id DESC
+`
+
+func (q *AuditQuery) sqliteArgs() []interface{} {
+	return []interface{}{q.Actor, q.Action, q.Target, nullTime(q.Since), nullTime(q.Until), q.BeforeID}
+}
+
+// GetAuditEntries returns the audit entries matching the passed in query, newest first
+func (s *SQLiteStore) GetAuditEntries(ctx context.Context, query *AuditQuery) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0, query.Limit)
+	err := s.db.SelectContext(ctx, &entries, sqliteSelectAuditEntriesSQL+"LIMIT ?7", append(query.sqliteArgs(), query.Limit)...)
+	if err != nil {
+		slog.Error("error selecting audit entries", "error", err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ExportAuditEntries calls fn for every audit entry matching the passed in query, newest first
+func (s *SQLiteStore) ExportAuditEntries(ctx context.Context, query *AuditQuery, fn func(*AuditEntry) error) error {
+	rows, err := s.db.QueryxContext(ctx, sqliteSelectAuditEntriesSQL, query.sqliteArgs()...)
+	if err != nil {
+		slog.Error("error exporting audit entries", "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := &AuditEntry{}
+		err = rows.StructScan(entry)
+		if err != nil {
+			return err
+		}
+
+		err = fn(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close closes our database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// sqliteTakeTokenSQL takes a token from a bucket like our postgres version, but with times stored as unix seconds
+// which we pass in as ?4
+const sqliteTakeTokenSQL = `
+INSERT INTO rate_limits (key, tokens, allowed, updated_on, full_on)
+VALUES (?1, ?3 - 1, 1, ?4, ?4 + 1.0 / ?2)
+ON CONFLICT (key)
+DO
+ UPDATE
+   SET allowed = (%[1]s) >= 1,
+       tokens = CASE WHEN (%[1]s) >= 1 THEN (%[1]s) - 1 ELSE (%[1]s) END,
+       updated_on = ?4,
+       full_on = ?4 + (?3 - CASE WHEN (%[1]s) >= 1 THEN (%[1]s) - 1 ELSE (%[1]s) END) / ?2
+RETURNING allowed
+`
+
+const sqliteAvailableTokensSQL = `MIN(?3, rate_limits.tokens + MAX(?4 - rate_limits.updated_on, 0) * ?2)`
+
+// TakeToken takes a token from the bucket with the passed in key, returning whether there was one to take
+func (s *SQLiteStore) TakeToken(ctx context.Context, key string, bucket *Bucket) (bool, error) {
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+
+	var allowed bool
+	err := s.db.GetContext(ctx, &allowed, fmt.Sprintf(sqliteTakeTokenSQL, sqliteAvailableTokensSQL), key, bucket.Rate, float64(bucket.Burst), now)
+	if err != nil {
+		slog.Error("error taking rate limit token", "error", err, "key", key)
+		return false, err
+	}
+	return allowed, nil
+}
+
+// PruneTokens removes the state of any buckets which have refilled
+func (s *SQLiteStore) PruneTokens(ctx context.Context) error {
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_on < ?`, now)
+	return err
+}
+
+// claims a key like our postgres version, but with times stored as unix seconds which we pass in as ?4
+const sqliteClaimDedupKeySQL = `
+INSERT INTO dedup_keys (interchange_uuid, key, status_code, body, expires_on)
+VALUES (?1, ?2, 0, NULL, ?4 + ?3)
+ON CONFLICT (interchange_uuid, key)
+DO
+ UPDATE
+   SET status_code = 0, body = NULL, expires_on = excluded.expires_on
+ WHERE dedup_keys.expires_on < ?4
+RETURNING key
+`
+
+// ClaimDedupKey claims the passed in key for an interchange, returning the response to its message if already claimed
+func (s *SQLiteStore) ClaimDedupKey(ctx context.Context, interchangeUUID string, key string, window time.Duration) (bool, *DedupResponse, error) {
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+
+	var claimed string
+	err := s.db.GetContext(ctx, &claimed, sqliteClaimDedupKeySQL, interchangeUUID, key, window.Seconds(), now)
+	if err == nil {
+		return true, nil, nil
+	}
+	if err != sql.ErrNoRows {
+		slog.Error("error claiming dedup key", "error", err)
+		return false, nil, err
+	}
+
+	response := &DedupResponse{}
+	err = s.db.GetContext(ctx, response, `SELECT status_code, body FROM dedup_keys WHERE interchange_uuid = ? AND key = ?`, interchangeUUID, key)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, response, nil
+}
+
+// CompleteDedupKey records the response to the message with a key we claimed
+func (s *SQLiteStore) CompleteDedupKey(ctx context.Context, interchangeUUID string, key string, response *DedupResponse) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE dedup_keys SET status_code = ?, body = ? WHERE interchange_uuid = ? AND key = ?`, response.StatusCode, response.Body, interchangeUUID, key)
+	return err
+}
+
+// ReleaseDedupKey releases a key we claimed but couldn't forward
+func (s *SQLiteStore) ReleaseDedupKey(ctx context.Context, interchangeUUID string, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM dedup_keys WHERE interchange_uuid = ? AND key = ?`, interchangeUUID, key)
+	return err
+}
+
+// PruneDedupKeys removes any keys which have expired
+func (s *SQLiteStore) PruneDedupKeys(ctx context.Context) error {
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	_, err := s.db.ExecContext(ctx, `DELETE FROM dedup_keys WHERE expires_on < ?`, now)
+	return err
+}
+
+const sqliteInsertHealthCheckSQL = `
+INSERT INTO health_checks (channel_uuid, healthy, status_code, error, elapsed_ms, checked_on)
+VALUES (:channel_uuid, :healthy, :status_code, :error, :elapsed_ms, :checked_on)
+`
+
+// InsertHealthCheck records the passed in health check result
+func (s *SQLiteStore) InsertHealthCheck(ctx context.Context, result *HealthCheckResult) error {
+	r := *result
+	r.CheckedOn = r.CheckedOn.UTC()
+
+	_, err := s.db.NamedExecContext(ctx, sqliteInsertHealthCheckSQL, &r)
+	if err != nil {
+		slog.Error("error inserting health check", "error", err, "channel_uuid", result.ChannelUUID)
+	}
+	return err
+}
+
+// GetHealthChecks returns the most recent health check results for the passed in channel, newest first
+func (s *SQLiteStore) GetHealthChecks(ctx context.Context, channelUUID string, limit int) ([]*HealthCheckResult, error) {
+	results := make([]*HealthCheckResult, 0, limit)
+	err := s.db.SelectContext(ctx, &results, `SELECT * FROM health_checks WHERE channel_uuid = ? ORDER BY id DESC LIMIT ?`, channelUUID, limit)
+	if err != nil {
+		slog.Error("error selecting health checks", "error", err, "channel_uuid", channelUUID)
+		return nil, err
+	}
+	return results, nil
+}
+
+// PruneHealthChecks removes the health check results from before the passed in time
+func (s *SQLiteStore) PruneHealthChecks(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM health_checks WHERE julianday(checked_on) < julianday(?)`, before.UTC())
+	return err
+}
+
+// ClaimHealthChecks always claims our health checks as sqlite databases are only used by a single instance
+func (s *SQLiteStore) ClaimHealthChecks(ctx context.Context, since time.Time) (func(), bool, error) {
+	return func() {}, true, nil
+}
@@ -0,0 +1,385 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/rp-clover/migrations"
+)
+
+// Store is where we keep our interchanges, mappings, users, tokens and audit log
+type Store interface {
+	// UpdateInterchangeConfig replaces our interchange config with the passed in interchanges
+	UpdateInterchangeConfig(ctx context.Context, interchanges []*Interchange) error
+
+	// GetInterchangeConfig returns our complete interchange config
+	GetInterchangeConfig(ctx context.Context) ([]*Interchange, error)
+
+	// GetInterchange returns the interchange with the passed in UUID with its default channel first, nil if it doesn't exist
+	GetInterchange(ctx context.Context, uuid string) (*Interchange, error)
+
+	// SetChannelForURN maps the passed in URN to the passed in channel
+	SetChannelForURN(ctx context.Context, interchange *Interchange, channel *Channel, urn string) error
+
+	// AssignChannelForURN maps the passed in URN to the passed in channel unless it has reached its capacity,
+	// returning whether it was mapped
+	AssignChannelForURN(ctx context.Context, interchange *Interchange, channel *Channel, urn string) (bool, error)
+
+	// GetChannelForURN returns the channel the passed in URN is mapped to, nil if it isn't mapped
+	GetChannelForURN(ctx context.Context, interchange *Interchange, urn string) (*Channel, error)
+
+	// ClearChannelForURN removes any mapping for the passed in URN
+	ClearChannelForURN(ctx context.Context, interchange *Interchange, urn string) error
+
+	// GetURNMapping returns the mapping for the passed in URN, nil if it isn't mapped
+	GetURNMapping(ctx context.Context, interchange *Interchange, urn string) (*URNMapping, error)
+
+	// ListURNMappings returns a page of the mappings for the passed in interchange ordered by URN
+	ListURNMappings(ctx context.Context, interchange *Interchange, query *URNMappingQuery) ([]*URNMapping, error)
+
+	// CountURNMappings returns the number of URNs mapped to each channel of the passed in interchange
+	CountURNMappings(ctx context.Context, interchange *Interchange) ([]*ChannelCount, error)
+
+	// GetMappedURNs returns which of the passed in URNs are mapped to a channel of the passed in interchange
+	GetMappedURNs(ctx context.Context, interchange *Interchange, urns []string) (map[string]bool, error)
+
+	// ExportURNMappings calls fn for every mapping of the passed in interchange, optionally limited to one channel
+	ExportURNMappings(ctx context.Context, interchangeUUID string, channelUUID string, fn func(*URNMapping) error) error
+
+	// SaveUser creates or updates the user with the passed in username, an empty password updates only their role
+	SaveUser(ctx context.Context, username string, password string, role Role) error
+
+	// GetUser returns the user with the passed in username, nil if they don't exist
+	GetUser(ctx context.Context, username string) (*User, error)
+
+	// GetUsers returns all our users ordered by username
+	GetUsers(ctx context.Context) ([]*User, error)
+
+	// DeleteUser deletes the user with the passed in username, returning whether they existed
+	DeleteUser(ctx context.Context, username string) (bool, error)
+
+	// AuthenticateUser returns the user with the passed in username if the password matches, nil otherwise
+	AuthenticateUser(ctx context.Context, username string, password string) (*User, error)
+
+	// CreateAPIToken creates a new API token, returning it and its secret value
+	CreateAPIToken(ctx context.Context, name string, permissions []Permission, interchangeUUIDs []string, createdBy string) (*APIToken, string, error)
+
+	// GetAPITokens returns all our API tokens ordered by id, including revoked ones
+	GetAPITokens(ctx context.Context) ([]*APIToken, error)
+
+	// GetAPIToken returns the token with the passed in id, including if it is revoked, nil if it doesn't exist
+	GetAPIToken(ctx context.Context, id int) (*APIToken, error)
+
+	// RevokeAPIToken revokes the token with the passed in id, returning whether an active token was revoked
+	RevokeAPIToken(ctx context.Context, id int) (bool, error)
+
+	// AuthenticateAPIToken returns the active token matching the passed in value, nil if there isn't one
+	AuthenticateAPIToken(ctx context.Context, value string) (*APIToken, error)
+
+	// InsertAuditEntry appends the passed in entry to our audit log
+	InsertAuditEntry(ctx context.Context, entry *AuditEntry) error
+
+	// GetAuditEntries returns the audit entries matching the passed in query, newest first
+	GetAuditEntries(ctx context.Context, query *AuditQuery) ([]*AuditEntry, error)
+
+	// ExportAuditEntries calls fn for every audit entry matching the passed in query, newest first
+	ExportAuditEntries(ctx context.Context, query *AuditQuery, fn func(*AuditEntry) error) error
+
+	// InsertHealthCheck records the passed in health check result
+	InsertHealthCheck(ctx context.Context, result *HealthCheckResult) error
+
+	// GetHealthChecks returns the most recent health check results for the passed in channel, newest first
+	GetHealthChecks(ctx context.Context, channelUUID string, limit int) ([]*HealthCheckResult, error)
+
+	// PruneHealthChecks removes the health check results from before the passed in time
+	PruneHealthChecks(ctx context.Context, before time.Time) error
+
+	// ClaimHealthChecks returns whether this instance should probe our channels, which it shouldn't if another
+	// instance is probing them or has since the passed in time. If claimed, release must be called once the results
+	// are recorded.
+	ClaimHealthChecks(ctx context.Context, since time.Time) (release func(), claimed bool, err error)
+
+	// our rate limit buckets are kept in the store so that limits are shared by all instances using it
+	RateLimiter
+
+	// as are the keys of the messages we have seen for duplicate suppression
+	Deduplicator
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+var _ Store = (*PostgresStore)(nil)
+var _ Store = (*SQLiteStore)(nil)
+var _ Store = (*MemoryStore)(nil)
+
+// the DSN prefixes which select our other stores, anything else is treated as a postgres URL
+const (
+	memoryDSNPrefix = "memory:"
+	sqliteDSNPrefix = "sqlite:"
+)
+
+// IsPostgresDSN returns whether the passed in DSN selects our postgres store
+func IsPostgresDSN(dsn string) bool {
+	return !strings.HasPrefix(dsn, memoryDSNPrefix) && !strings.HasPrefix(dsn, sqliteDSNPrefix)
+}
+
+// OpenStore opens the store for the passed in DSN, one of a postgres URL, sqlite:<path> for an embedded sqlite
+// database or memory: for a store which only lives as long as this process
+func OpenStore(ctx context.Context, dsn string) (Store, error) {
+	switch {
+	case strings.HasPrefix(dsn, memoryDSNPrefix):
+		return NewMemoryStore(), nil
+	case strings.HasPrefix(dsn, sqliteDSNPrefix):
+		return OpenSQLiteStore(ctx, strings.TrimPrefix(dsn, sqliteDSNPrefix))
+	}
+	return OpenPostgresStore(ctx, dsn)
+}
+
+// PostgresStore is a store backed by a postgres database
+type PostgresStore struct {
+	db       *sqlx.DB
+	listener *CacheListener
+}
+
+// OpenPostgresStore connects to the passed in database, migrates it forward and starts listening for interchange
+// changes made by other instances
+func OpenPostgresStore(ctx context.Context, dsn string) (*PostgresStore, error) {
+	db, err := openPostgresDB(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrations.Migrate(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	listener, err := StartCacheListener(dsn)
+	if err != nil {
+		slog.Error("unable to start interchange listener", "error", err)
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresStore{db: db, listener: listener}, nil
+}
+
+func openPostgresDB(ctx context.Context, dsn string) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(4)
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		slog.Error("unable to ping database", "error", err)
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// OpenDB opens the database for the passed in DSN without migrating it, used to manage migrations
+func OpenDB(ctx context.Context, dsn string) (*sqlx.DB, error) {
+	switch {
+	case strings.HasPrefix(dsn, memoryDSNPrefix):
+		return nil, fmt.Errorf("memory stores have no database")
+	case strings.HasPrefix(dsn, sqliteDSNPrefix):
+		return openSQLiteDB(ctx, strings.TrimPrefix(dsn, sqliteDSNPrefix))
+	}
+	return openPostgresDB(ctx, dsn)
+}
+
+// DB returns the database for this store
+func (s *PostgresStore) DB() *sqlx.DB {
+	return s.db
+}
+
+// UpdateInterchangeConfig replaces our interchange config with the passed in interchanges
+func (s *PostgresStore) UpdateInterchangeConfig(ctx context.Context, interchanges []*Interchange) error {
+	return UpdateInterchangeConfig(ctx, s.db, interchanges)
+}
+
+// GetInterchangeConfig returns our complete interchange config
+func (s *PostgresStore) GetInterchangeConfig(ctx context.Context) ([]*Interchange, error) {
+	return GetInterchangeConfig(ctx, s.db)
+}
+
+// GetInterchange returns the interchange with the passed in UUID
+func (s *PostgresStore) GetInterchange(ctx context.Context, uuid string) (*Interchange, error) {
+	return GetInterchange(ctx, s.db, uuid)
+}
+
+// SetChannelForURN maps the passed in URN to the passed in channel
+func (s *PostgresStore) SetChannelForURN(ctx context.Context, interchange *Interchange, channel *Channel, urn string) error {
+	return SetChannelForURN(ctx, s.db, interchange, channel, urn)
+}
+
+// GetChannelForURN returns the channel the passed in URN is mapped to
+func (s *PostgresStore) GetChannelForURN(ctx context.Context, interchange *Interchange, urn string) (*Channel, error) {
+	return GetChannelForURN(ctx, s.db, interchange, urn)
+}
+
+// AssignChannelForURN maps the passed in URN to the passed in channel unless it has reached its capacity
+func (s *PostgresStore) AssignChannelForURN(ctx context.Context, interchange *Interchange, channel *Channel, urn string) (bool, error) {
+	return AssignChannelForURN(ctx, s.db, interchange, channel, urn)
+}
+
+// ClearChannelForURN removes any mapping for the passed in URN
+func (s *PostgresStore) ClearChannelForURN(ctx context.Context, interchange *Interchange, urn string) error {
+	return ClearChannelForURN(ctx, s.db, interchange, urn)
+}
+
+// GetURNMapping returns the mapping for the passed in URN
+func (s *PostgresStore) GetURNMapping(ctx context.Context, interchange *Interchange, urn string) (*URNMapping, error) {
+	return GetURNMapping(ctx, s.db, interchange, urn)
+}
+
+// ListURNMappings returns a page of the mappings for the passed in interchange
+func (s *PostgresStore) ListURNMappings(ctx context.Context, interchange *Interchange, query *URNMappingQuery) ([]*URNMapping, error) {
+	return ListURNMappings(ctx, s.db, interchange, query)
+}
+
+// CountURNMappings returns the number of URNs mapped to each channel of the passed in interchange
+func (s *PostgresStore) CountURNMappings(ctx context.Context, interchange *Interchange) ([]*ChannelCount, error) {
+	return CountURNMappings(ctx, s.db, interchange)
+}
+
+// GetMappedURNs returns which of the passed in URNs are mapped to a channel of the passed in interchange
+func (s *PostgresStore) GetMappedURNs(ctx context.Context, interchange *Interchange, urns []string) (map[string]bool, error) {
+	return GetMappedURNs(ctx, s.db, interchange, urns)
+}
+
+// ExportURNMappings calls fn for every mapping of the passed in interchange
+func (s *PostgresStore) ExportURNMappings(ctx context.Context, interchangeUUID string, channelUUID string, fn func(*URNMapping) error) error {
+	return ExportURNMappings(ctx, s.db, interchangeUUID, channelUUID, fn)
+}
+
+// SaveUser creates or updates the user with the passed in username
+func (s *PostgresStore) SaveUser(ctx context.Context, username string, password string, role Role) error {
+	return SaveUser(ctx, s.db, username, password, role)
+}
+
+// GetUser returns the user with the passed in username
+func (s *PostgresStore) GetUser(ctx context.Context, username string) (*User, error) {
+	return GetUser(ctx, s.db, username)
+}
+
+// GetUsers returns all our users
+func (s *PostgresStore) GetUsers(ctx context.Context) ([]*User, error) {
+	return GetUsers(ctx, s.db)
+}
+
+// DeleteUser deletes the user with the passed in username
+func (s *PostgresStore) DeleteUser(ctx context.Context, username string) (bool, error) {
+	return DeleteUser(ctx, s.db, username)
+}
+
+// AuthenticateUser returns the user with the passed in username if the password matches
+func (s *PostgresStore) AuthenticateUser(ctx context.Context, username string, password string) (*User, error) {
+	return AuthenticateUser(ctx, s.db, username, password)
+}
+
+// CreateAPIToken creates a new API token
+func (s *PostgresStore) CreateAPIToken(ctx context.Context, name string, permissions []Permission, interchangeUUIDs []string, createdBy string) (*APIToken, string, error) {
+	return CreateAPIToken(ctx, s.db, name, permissions, interchangeUUIDs, createdBy)
+}
+
+// GetAPITokens returns all our API tokens
+func (s *PostgresStore) GetAPITokens(ctx context.Context) ([]*APIToken, error) {
+	return GetAPITokens(ctx, s.db)
+}
+
+// GetAPIToken returns the token with the passed in id
+func (s *PostgresStore) GetAPIToken(ctx context.Context, id int) (*APIToken, error) {
+	return GetAPIToken(ctx, s.db, id)
+}
+
+// RevokeAPIToken revokes the token with the passed in id
+func (s *PostgresStore) RevokeAPIToken(ctx context.Context, id int) (bool, error) {
+	return RevokeAPIToken(ctx, s.db, id)
+}
+
+// AuthenticateAPIToken returns the active token matching the passed in value
+func (s *PostgresStore) AuthenticateAPIToken(ctx context.Context, value string) (*APIToken, error) {
+	return AuthenticateAPIToken(ctx, s.db, value)
+}
+
+// InsertAuditEntry appends the passed in entry to our audit log
+func (s *PostgresStore) InsertAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	return InsertAuditEntry(ctx, s.db, entry)
+}
+
+// GetAuditEntries returns the audit entries matching the passed in query
+func (s *PostgresStore) GetAuditEntries(ctx context.Context, query *AuditQuery) ([]*AuditEntry, error) {
+	return GetAuditEntries(ctx, s.db, query)
+}
+
+// ExportAuditEntries calls fn for every audit entry matching the passed in query
+func (s *PostgresStore) ExportAuditEntries(ctx context.Context, query *AuditQuery, fn func(*AuditEntry) error) error {
+	return ExportAuditEntries(ctx, s.db, query, fn)
+}
+
+// TakeToken takes a token from the bucket with the passed in key, returning whether there was one to take
+func (s *PostgresStore) TakeToken(ctx context.Context, key string, bucket *Bucket) (bool, error) {
+	return TakeRateLimitToken(ctx, s.db, key, bucket)
+}
+
+// PruneTokens removes the state of any buckets which have refilled
+func (s *PostgresStore) PruneTokens(ctx context.Context) error {
+	return PruneRateLimits(ctx, s.db)
+}
+
+// ClaimDedupKey claims the passed in key for an interchange, returning the response to its message if already claimed
+func (s *PostgresStore) ClaimDedupKey(ctx context.Context, interchangeUUID string, key string, window time.Duration) (bool, *DedupResponse, error) {
+	return ClaimDedupKey(ctx, s.db, interchangeUUID, key, window)
+}
+
+// CompleteDedupKey records the response to the message with a key we claimed
+func (s *PostgresStore) CompleteDedupKey(ctx context.Context, interchangeUUID string, key string, response *DedupResponse) error {
+	return CompleteDedupKey(ctx, s.db, interchangeUUID, key, response)
+}
+
+// ReleaseDedupKey releases a key we claimed but couldn't forward
+func (s *PostgresStore) ReleaseDedupKey(ctx context.Context, interchangeUUID string, key string) error {
+	return ReleaseDedupKey(ctx, s.db, interchangeUUID, key)
+}
+
+// PruneDedupKeys removes any keys which have expired
+func (s *PostgresStore) PruneDedupKeys(ctx context.Context) error {
+	return PruneDedupKeys(ctx, s.db)
+}
+
+// Close stops listening for interchange changes and closes our database
+func (s *PostgresStore) Close() error {
+	s.listener.Stop()
+	return s.db.Close()
+}
+
+// InsertHealthCheck records the passed in health check result
+func (s *PostgresStore) InsertHealthCheck(ctx context.Context, result *HealthCheckResult) error {
+	return InsertHealthCheck(ctx, s.db, result)
+}
+
+// GetHealthChecks returns the most recent health check results for the passed in channel, newest first
+func (s *PostgresStore) GetHealthChecks(ctx context.Context, channelUUID string, limit int) ([]*HealthCheckResult, error) {
+	return GetHealthChecks(ctx, s.db, channelUUID, limit)
+}
+
+// PruneHealthChecks removes the health check results from before the passed in time
+func (s *PostgresStore) PruneHealthChecks(ctx context.Context, before time.Time) error {
+	return PruneHealthChecks(ctx, s.db, before)
+}
+
+// ClaimHealthChecks returns whether this instance should probe our channels
+func (s *PostgresStore) ClaimHealthChecks(ctx context.Context, since time.Time) (func(), bool, error) {
+	return ClaimHealthChecks(ctx, s.db, since)
+}
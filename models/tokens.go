@@ -0,0 +1,184 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// the permissions which can be granted to API tokens, tokens can't manage users or other tokens
+var tokenPermissions = map[Permission]bool{
+	PermissionConfigRead:    true,
+	PermissionConfigWrite:   true,
+	PermissionMappingsRead:  true,
+	PermissionMappingsWrite: true,
+}
+
+// the prefix for all our tokens, makes them easy to recognize
+const tokenPrefix = "clv_"
+
+// APIToken is a token machine clients can use to access the admin API, limited to a set of permissions and
+// optionally a set of interchanges
+type APIToken struct {
+	ID               int            `db:"id"                json:"id"`
+	Name             string         `db:"name"              json:"name"`
+	TokenHash        string         `db:"token_hash"        json:"-"`
+	Permissions      pq.StringArray `db:"permissions"       json:"permissions"`
+	InterchangeUUIDs pq.StringArray `db:"interchange_uuids" json:"interchange_uuids"`
+	CreatedBy        string         `db:"created_by"        json:"created_by"`
+	CreatedOn        time.Time      `db:"created_on"        json:"created_on"`
+	LastUsedOn       *time.Time     `db:"last_used_on"      json:"last_used_on"`
+	RevokedOn        *time.Time     `db:"revoked_on"        json:"revoked_on"`
+}
+
+// GrantedPermissions returns the permissions granted to this token
+func (t *APIToken) GrantedPermissions() []Permission {
+	perms := make([]Permission, len(t.Permissions))
+	for i := range t.Permissions {
+		perms[i] = Permission(t.Permissions[i])
+	}
+	return perms
+}
+
+const insertAPITokenSQL = `
+INSERT INTO api_tokens (name, token_hash, permissions, interchange_uuids, created_by, created_on)
+VALUES (:name, :token_hash, :permissions, :interchange_uuids, :created_by, NOW())
+RETURNING id
+`
+
+// CreateAPIToken creates a new API token, returning it along with the secret token value which is not stored and
+// so can't be retrieved later. An empty list of interchange UUIDs gives the token access to all interchanges.
+func CreateAPIToken(ctx context.Context, db *sqlx.DB, name string, permissions []Permission, interchangeUUIDs []string, createdBy string) (*APIToken, string, error) {
+	token, value, err := newAPIToken(name, permissions, interchangeUUIDs, createdBy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := db.NamedQueryContext(ctx, insertAPITokenSQL, token)
+	if err != nil {
+		slog.Error("error inserting api token", "error", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	rows.Next()
+	err = rows.Scan(&token.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err = GetAPIToken(ctx, db, token.ID)
+	return token, value, err
+}
+
+// newAPIToken creates a new validated token with a random value, returning it and the value
+func newAPIToken(name string, permissions []Permission, interchangeUUIDs []string, createdBy string) (*APIToken, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("token name is required")
+	}
+	if len(permissions) == 0 {
+		return nil, "", fmt.Errorf("token must have at least one permission")
+	}
+
+	token := &APIToken{
+		Name:             name,
+		Permissions:      make(pq.StringArray, len(permissions)),
+		InterchangeUUIDs: pq.StringArray(interchangeUUIDs),
+		CreatedBy:        createdBy,
+	}
+	if token.InterchangeUUIDs == nil {
+		token.InterchangeUUIDs = pq.StringArray{}
+	}
+
+	for i, perm := range permissions {
+		if !tokenPermissions[perm] {
+			return nil, "", fmt.Errorf("invalid token permission: %s", perm)
+		}
+		token.Permissions[i] = string(perm)
+	}
+
+	for _, uuid := range interchangeUUIDs {
+		err := validate.Var(uuid, "uuid")
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid interchange UUID: %s", uuid)
+		}
+	}
+
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, "", err
+	}
+	value := tokenPrefix + hex.EncodeToString(secret)
+	token.TokenHash = hashToken(value)
+
+	return token, value, nil
+}
+
+// GetAPIToken returns the token with the passed in id, including if it is revoked, nil if it doesn't exist
+func GetAPIToken(ctx context.Context, db *sqlx.DB, id int) (*APIToken, error) {
+	token := &APIToken{}
+	err := db.GetContext(ctx, token, `SELECT * FROM api_tokens WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// GetAPITokens returns all our API tokens, including revoked ones
+func GetAPITokens(ctx context.Context, db *sqlx.DB) ([]*APIToken, error) {
+	tokens := make([]*APIToken, 0, 5)
+	err := db.SelectContext(ctx, &tokens, `SELECT * FROM api_tokens ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken revokes the token with the passed in id, returning whether an active token was revoked
+func RevokeAPIToken(ctx context.Context, db *sqlx.DB, id int) (bool, error) {
+	result, err := db.ExecContext(ctx, `UPDATE api_tokens SET revoked_on = NOW() WHERE id = $1 AND revoked_on IS NULL`, id)
+	if err != nil {
+		slog.Error("error revoking api token", "error", err)
+		return false, err
+	}
+
+	revoked, err := result.RowsAffected()
+	return revoked > 0, err
+}
+
+const useAPITokenSQL = `
+UPDATE api_tokens 
+   SET last_used_on = NOW()
+ WHERE token_hash = $1 AND revoked_on IS NULL
+RETURNING *
+`
+
+// AuthenticateAPIToken returns the active token matching the passed in value, recording that it was used. If no
+// active token matches, nil is returned.
+func AuthenticateAPIToken(ctx context.Context, db *sqlx.DB, value string) (*APIToken, error) {
+	token := &APIToken{}
+	err := db.GetContext(ctx, token, useAPITokenSQL, hashToken(value))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		slog.Error("error authenticating api token", "error", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+// tokens are long and random, so a plain hash is enough to keep them safe at rest and still allow lookups
+func hashToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// how long cached mappings are trusted for, this bounds how stale we can be if we miss a change made by another
+// instance, such as while our listener is reconnecting
+const urnCacheTTL = time.Minute
+
+// URNCacheStats are the statistics for our URN mapping cache
+type URNCacheStats struct {
+	Capacity int   `json:"capacity"`
+	Size     int   `json:"size"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+}
+
+type urnCacheEntry struct {
+	key string
+
+	// the UUID of the mapped channel, empty if the URN has no mapping
+	channelUUID string
+	loadedOn    time.Time
+}
+
+// urnCache is a bounded LRU cache of which channel URNs are mapped to, including which URNs have no mapping. Changes
+// made by other instances are dropped by our CacheListener when they are published.
+type urnCache struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  *list.List
+	index    map[string]*list.Element
+
+	// incremented on every write so that lookups which raced with a write don't cache stale results
+	version uint64
+
+	hits   int64
+	misses int64
+}
+
+func newURNCache(capacity int) *urnCache {
+	return &urnCache{
+		capacity: capacity,
+		entries:  list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+func urnCacheKey(interchangeUUID string, urn string) string {
+	return interchangeUUID + "|" + urn
+}
+
+// get returns the cached channel UUID for the passed in URN and whether it was found, along with the cache version
+// which should be passed to put if the lookup missed. Cached misses are found with an empty channel UUID and count as
+// hits.
+func (c *urnCache) get(interchangeUUID string, urn string) (string, bool, uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, found := c.index[urnCacheKey(interchangeUUID, urn)]
+	if found {
+		entry := elem.Value.(*urnCacheEntry)
+		if time.Since(entry.loadedOn) < urnCacheTTL {
+			c.entries.MoveToFront(elem)
+			c.hits++
+			return entry.channelUUID, true, c.version
+		}
+
+		c.remove(elem)
+	}
+
+	c.misses++
+	return "", false, c.version
+}
+
+// put caches the result of a lookup, an empty channel UUID if it found no mapping, unless the cache has been written to since the passed in version
+func (c *urnCache) put(interchangeUUID string, urn string, channelUUID string, version uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if version != c.version {
+		return
+	}
+	c.set(interchangeUUID, urn, channelUUID)
+}
+
+// write records a change to a mapping
+func (c *urnCache) write(interchangeUUID string, urn string, channelUUID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.version++
+	c.set(interchangeUUID, urn, channelUUID)
+}
+
+// drop records that a mapping was added, removed or changed elsewhere, so it is reloaded on next use
+func (c *urnCache) drop(interchangeUUID string, urn string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.version++
+	if elem, found := c.index[urnCacheKey(interchangeUUID, urn)]; found {
+		c.remove(elem)
+	}
+}
+
+// clear drops everything we have cached
+func (c *urnCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.version++
+	c.entries.Init()
+	c.index = make(map[string]*list.Element)
+}
+
+func (c *urnCache) set(interchangeUUID string, urn string, channelUUID string) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	key := urnCacheKey(interchangeUUID, urn)
+	if elem, found := c.index[key]; found {
+		entry := elem.Value.(*urnCacheEntry)
+		entry.channelUUID = channelUUID
+		entry.loadedOn = time.Now()
+		c.entries.MoveToFront(elem)
+		return
+	}
+
+	c.index[key] = c.entries.PushFront(&urnCacheEntry{key: key, channelUUID: channelUUID, loadedOn: time.Now()})
+
+	for c.entries.Len() > c.capacity {
+		c.remove(c.entries.Back())
+	}
+}
+
+func (c *urnCache) remove(elem *list.Element) {
+	c.entries.Remove(elem)
+	delete(c.index, elem.Value.(*urnCacheEntry).key)
+}
+
+func (c *urnCache) stats() *URNCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &URNCacheStats{Capacity: c.capacity, Size: c.entries.Len(), Hits: c.hits, Misses: c.misses}
+}
+
+// our shared cache of URN mappings
+var mappingCache = newURNCache(defaultURNCacheSize)
+
+// the default number of URN mappings we cache
+const defaultURNCacheSize = 100000
+
+// SetURNCacheSize sets the maximum number of URN mappings we cache, clearing the cache. A size of zero disables it.
+func SetURNCacheSize(size int) {
+	cache := newURNCache(size)
+
+	cacheLock.Lock()
+	mappingCache = cache
+	cacheLock.Unlock()
+}
+
+// GetURNCacheStats returns the current statistics for our URN mapping cache
+func GetURNCacheStats() *URNCacheStats {
+	return urnMappingCache().stats()
+}
+
+func urnMappingCache() *urnCache {
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	return mappingCache
+}
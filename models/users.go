@@ -0,0 +1,211 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Permission is something a user is allowed to do through the admin API
+type Permission string
+
+// the permissions we support
+const (
+	PermissionAuditRead     = Permission("audit:read")
+	PermissionConfigRead    = Permission("config:read")
+	PermissionConfigWrite   = Permission("config:write")
+	PermissionMappingsRead  = Permission("mappings:read")
+	PermissionMappingsWrite = Permission("mappings:write")
+	PermissionUsersWrite    = Permission("users:write")
+	PermissionTokensWrite   = Permission("tokens:write")
+)
+
+// Role is the role of an admin user, which determines what permissions they have
+type Role string
+
+// the roles we support, each includes all the permissions of the roles before it
+const (
+	RoleViewer   = Role("viewer")
+	RoleOperator = Role("operator")
+	RoleAdmin    = Role("admin")
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:   {PermissionConfigRead, PermissionMappingsRead, PermissionAuditRead},
+	RoleOperator: {PermissionConfigRead, PermissionMappingsRead, PermissionAuditRead, PermissionMappingsWrite},
+	RoleAdmin:    {PermissionConfigRead, PermissionMappingsRead, PermissionAuditRead, PermissionMappingsWrite, PermissionConfigWrite, PermissionUsersWrite, PermissionTokensWrite},
+}
+
+// Permissions returns the permissions granted to this role
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// User is an admin user which can log into clover
+type User struct {
+	Username     string    `db:"username"      json:"username"     validate:"required,max=64,printascii,excludesall=: "`
+	PasswordHash string    `db:"password_hash" json:"-"`
+	Role         Role      `db:"role"          json:"role"         validate:"required,oneof=viewer operator admin"`
+	CreatedOn    time.Time `db:"created_on"    json:"created_on"`
+	ModifiedOn   time.Time `db:"modified_on"   json:"modified_on"`
+}
+
+// the minimum length we allow for passwords
+const minPasswordLength = 8
+
+// returned when only updating the role of a user who doesn't exist
+var errPasswordRequired = fmt.Errorf("password is required for new users")
+
+// hash we compare against when authenticating users that don't exist
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
+const upsertUserSQL = `
+INSERT INTO users (username, password_hash, role, created_on, modified_on)
+VALUES (:username, :password_hash, :role, NOW(), NOW())
+ON CONFLICT (username)
+DO
+ UPDATE
+   SET password_hash = :password_hash, role = :role, modified_on = NOW()
+`
+
+const updateUserRoleSQL = `
+UPDATE users
+   SET role = :role, modified_on = NOW()
+ WHERE username = :username
+`
+
+// SaveUser creates the user with the passed in username or updates their password and role if they already exist.
+// An empty password updates only the role of an existing user.
+func SaveUser(ctx context.Context, db *sqlx.DB, username string, password string, role Role) error {
+	if password == "" {
+		user, err := newRoleUpdate(username, role)
+		if err != nil {
+			return err
+		}
+
+		result, err := db.NamedExecContext(ctx, updateUserRoleSQL, user)
+		if err != nil {
+			slog.Error("error updating user role", "error", err)
+			return err
+		}
+		return checkRoleUpdated(result)
+	}
+
+	user, err := newUser(username, password, role)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.NamedExecContext(ctx, upsertUserSQL, user)
+	if err != nil {
+		slog.Error("error upserting user", "error", err)
+	}
+
+	return err
+}
+
+// newUser creates a new validated user with the passed in password hashed
+func newUser(username string, password string, role Role) (*User, error) {
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{Username: username, PasswordHash: string(hash), Role: role}
+	err = validateObject(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// newRoleUpdate creates a validated user for updating only the role of an existing user
+func newRoleUpdate(username string, role Role) (*User, error) {
+	user := &User{Username: username, Role: role}
+	err := validateObject(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// checkRoleUpdated returns an error if the passed in result of updating a user's role didn't find the user, as new
+// users need a password
+func checkRoleUpdated(result sql.Result) error {
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errPasswordRequired
+	}
+	return nil
+}
+
+// checkPassword returns whether the passed in password matches this user's, user may be nil in which case we still
+// do a comparison so that unknown users can't be discovered by timing
+func (u *User) checkPassword(password string) bool {
+	if u == nil {
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// GetUser returns the user with the passed in username, if any
+func GetUser(ctx context.Context, db *sqlx.DB, username string) (*User, error) {
+	user := &User{}
+	err := db.GetContext(ctx, user, `SELECT * FROM users WHERE username = $1`, username)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return user, err
+}
+
+// GetUsers returns all our users ordered by username
+func GetUsers(ctx context.Context, db *sqlx.DB) ([]*User, error) {
+	users := make([]*User, 0, 5)
+	err := db.SelectContext(ctx, &users, `SELECT * FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteUser deletes the user with the passed in username, returning whether they existed
+func DeleteUser(ctx context.Context, db *sqlx.DB, username string) (bool, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM users WHERE username = $1`, username)
+	if err != nil {
+		slog.Error("error deleting user", "error", err)
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// AuthenticateUser returns the user with the passed in username if the password matches, nil otherwise
+func AuthenticateUser(ctx context.Context, db *sqlx.DB, username string, password string) (*User, error) {
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.checkPassword(password) {
+		return nil, nil
+	}
+
+	return user, nil
+}
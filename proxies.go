@@ -0,0 +1,74 @@
+package clover
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// parseTrustedProxies parses our comma separated list of trusted proxy IPs and CIDRs
+func parseTrustedProxies(config string) ([]netip.Prefix, error) {
+	proxies := make([]netip.Prefix, 0)
+	for _, entry := range strings.Split(config, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		network, err := models.ParseNetwork(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// realIP sets the remote address of requests from the forwarding headers set by our proxies. Forwarding headers on
+// requests which don't come from a trusted proxy are dropped, and we take the client address to be the last one in
+// X-Forwarded-For which isn't a trusted proxy, so clients can't spoof their address by sending these headers. Most
+// proxies pass X-Real-IP through from clients, so we only use it if we are configured to trust our proxies to set it.
+func (s *Server) realIP(next http.Handler) http.Handler {
+	withRealIP := middleware.RealIP(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !models.NetworksContain(s.trustedProxies, sourceIP(r)) {
+			r.Header.Del("X-Real-IP")
+			r.Header.Del("X-Forwarded-For")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.config.TrustRealIP {
+			r.Header.Del("X-Real-IP")
+		}
+
+		// if our proxy didn't set X-Real-IP, we find the client in X-Forwarded-For
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 && r.Header.Get("X-Real-IP") == "" {
+			hops := strings.Split(strings.Join(forwarded, ","), ",")
+
+			client := strings.TrimSpace(hops[0])
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if !models.NetworksContain(s.trustedProxies, hop) {
+					client = hop
+					break
+				}
+			}
+			r.Header.Set("X-Forwarded-For", client)
+		}
+
+		withRealIP.ServeHTTP(w, r)
+	})
+}
+
+// sourceIP returns the IP address of the client making the passed in request
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,61 @@
+package clover
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// where rate limit state can be kept
+const (
+	rateLimiterStore  = "store"
+	rateLimiterMemory = "memory"
+)
+
+// how often we remove the state of rate limit buckets which have refilled
+var rateLimitPruneInterval = time.Minute
+
+// newRateLimiter returns the rate limiter selected by our config
+func (s *Server) newRateLimiter() (models.RateLimiter, error) {
+	switch s.config.RateLimiter {
+	case rateLimiterStore:
+		return s.store, nil
+	case rateLimiterMemory:
+		return models.NewMemoryRateLimiter(), nil
+	}
+	return nil, fmt.Errorf("unknown rate limiter '%s', must be one of %s or %s", s.config.RateLimiter, rateLimiterStore, rateLimiterMemory)
+}
+
+// overRateLimit returns whether a request from the passed in URN is over any of the rate limits of its interchange.
+// We check the URN's limit first so that a single noisy sender doesn't use up the limit of the whole interchange.
+// If we can't check a limit we let the request through rather than refusing all traffic.
+func (s *Server) overRateLimit(ctx context.Context, interchange *models.Interchange, urn string) bool {
+	limit := interchange.RateLimit
+
+	checks := []struct {
+		key    string
+		bucket *models.Bucket
+	}{
+		{fmt.Sprintf("urn:%s:%s", interchange.UUID, urn), limit.URN},
+		{fmt.Sprintf("interchange:%s", interchange.UUID), limit.Interchange},
+	}
+
+	for _, check := range checks {
+		if check.bucket == nil {
+			continue
+		}
+
+		allowed, err := s.limiter.TakeToken(ctx, check.key, check.bucket)
+		if err != nil {
+			slog.Error("error checking rate limit, allowing request", "error", err, "key", check.key)
+			continue
+		}
+		if !allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package clover
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// roundRobins is the next channel to assign for each interchange which distributes senders round robin, these are
+// kept per instance so senders are only evenly spread across channels by each instance
+type roundRobins struct {
+	mutex sync.Mutex
+	next  map[string]int
+}
+
+func newRoundRobins() *roundRobins {
+	return &roundRobins{next: make(map[string]int)}
+}
+
+// take returns the next index for the passed in interchange, wrapped to the passed in number of channels
+func (r *roundRobins) take(interchangeUUID string, channels int) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	next := r.next[interchangeUUID] % channels
+	r.next[interchangeUUID] = next + 1
+	return next
+}
+
+// distributeSender picks the channel for a sender which has no mapping according to our interchange's distribution
+// strategy, returning it and why it was picked. Unhealthy channels are skipped unless they all are. Unless we use
+// our default channel or hashing, the sender is mapped to the channel we pick so that they stay with it. Hashing
+// always picks the same channel for a sender while it is healthy so there is no need to store a mapping. If the
+// channel we pick has reached its capacity, the sender overflows or is refused.
+func (s *Server) distributeSender(ctx context.Context, interchange *models.Interchange, urn string) (*models.Channel, string, error) {
+	candidates := s.healthyChannels(interchange)
+
+	var channel *models.Channel
+	var reason string
+
+	switch interchange.Distribution {
+	case models.DistributionWeighted:
+		total := 0
+		for _, c := range candidates {
+			total += c.DistributionWeight()
+		}
+		channel, reason = pickWeighted(candidates, rand.IntN(total)), "weighted distribution"
+
+	case models.DistributionRoundRobin:
+		channel, reason = candidates[s.roundRobins.take(interchange.UUID, len(candidates))], "round robin distribution"
+
+	case models.DistributionFill:
+		counts, err := s.mappedCounts(ctx, interchange)
+		if err != nil {
+			return nil, "", err
+		}
+		channel, reason = pickUnfilled(candidates, counts), "fill distribution"
+		if channel == nil {
+			channel, reason = candidates[0], "fill distribution, all channels at capacity"
+		}
+
+	case models.DistributionHash:
+		// we hash over all our channels so that senders only move when their channel is unhealthy, and then to the
+		// healthy channel they score highest for so that they return once it recovers
+		channel, reason = pickHashed(allChannels(interchange), urn), "hash distribution"
+		if !s.health.healthy(channel.UUID) {
+			if failover := pickHashed(candidates, urn); failover.UUID != channel.UUID {
+				channel, reason = failover, fmt.Sprintf("hash distribution, failed over from %s", channel.UUID)
+			}
+		}
+
+	default:
+		channel, reason = candidates[0], "default channel"
+		if channel.UUID != interchange.Channels[0].UUID {
+			reason = "default channel unhealthy"
+		}
+	}
+
+	// senders going to a channel with a capacity are always mapped so that they count towards it
+	if channel.Capacity == 0 && (interchange.Distribution == "" || interchange.Distribution == models.DistributionDefault || interchange.Distribution == models.DistributionHash) {
+		return channel, reason, nil
+	}
+
+	assigned, err := s.assignChannel(ctx, interchange, channel, urn)
+	if err != nil {
+		return nil, "", err
+	}
+	if assigned.UUID != channel.UUID {
+		reason = fmt.Sprintf("%s, overflowed from %s", reason, channel.UUID)
+	}
+	return assigned, reason, nil
+}
+
+// channelFullError is returned when a sender can't be assigned to a channel because it and any channels it
+// overflows to have reached their capacities, the channel is the last one we tried
+type channelFullError struct {
+	channel *models.Channel
+}
+
+func (e *channelFullError) Error() string {
+	return fmt.Sprintf("channel %s is full", e.channel.UUID)
+}
+
+// assignChannel maps a sender to the passed in channel, or if that has reached its capacity, to the first channel
+// it overflows to which hasn't, returning the channel they were mapped to. Senders already mapped to a channel can
+// always stay with it.
+func (s *Server) assignChannel(ctx context.Context, interchange *models.Interchange, channel *models.Channel, urn string) (*models.Channel, error) {
+	current, err := s.store.GetChannelForURN(ctx, interchange, urn)
+	if err != nil {
+		return nil, err
+	}
+
+	tried := make(map[string]bool)
+	for {
+		tried[channel.UUID] = true
+
+		if channel.Capacity == 0 || (current != nil && current.UUID == channel.UUID) {
+			err = s.store.SetChannelForURN(ctx, interchange, channel, urn)
+			if err != nil {
+				return nil, fmt.Errorf("error mapping sender: %w", err)
+			}
+			return channel, nil
+		}
+
+		// the store checks our capacity as it maps the sender so that concurrent assignments can't overfill us
+		assigned, err := s.store.AssignChannelForURN(ctx, interchange, channel, urn)
+		if err != nil {
+			return nil, fmt.Errorf("error mapping sender: %w", err)
+		}
+		if assigned {
+			return channel, nil
+		}
+
+		s.overflows.inc(channel.UUID)
+
+		var overflow *models.Channel
+		if channel.WhenFull != nil {
+			overflow = interchange.GetChannel(channel.WhenFull.Overflow)
+		}
+		if overflow == nil || tried[overflow.UUID] {
+			return nil, &channelFullError{channel: channel}
+		}
+		channel = overflow
+	}
+}
+
+// mappedCounts returns the number of URNs mapped to each channel of our interchange
+func (s *Server) mappedCounts(ctx context.Context, interchange *models.Interchange) (map[string]int, error) {
+	counts, err := s.store.CountURNMappings(ctx, interchange)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := make(map[string]int, len(counts))
+	for _, count := range counts {
+		mapped[count.ChannelUUID] = count.Count
+	}
+	return mapped, nil
+}
+
+// healthyChannels returns the channels of our interchange which are healthy, or all of them if none are, in order
+// with the default channel first
+func (s *Server) healthyChannels(interchange *models.Interchange) []*models.Channel {
+	healthy := make([]*models.Channel, 0, len(interchange.Channels))
+	for c := range interchange.Channels {
+		if s.health.healthy(interchange.Channels[c].UUID) {
+			healthy = append(healthy, &interchange.Channels[c])
+		}
+	}
+	if len(healthy) > 0 {
+		return healthy
+	}
+	return allChannels(interchange)
+}
+
+// allChannels returns all the channels of our interchange in order with the default channel first
+func allChannels(interchange *models.Interchange) []*models.Channel {
+	all := make([]*models.Channel, len(interchange.Channels))
+	for c := range interchange.Channels {
+		all[c] = &interchange.Channels[c]
+	}
+	return all
+}
+
+// pickWeighted returns the channel which the passed in number, from zero up to the total of the channels' weights,
+// falls on when the channels' weights are laid end to end
+func pickWeighted(channels []*models.Channel, n int) *models.Channel {
+	for _, channel := range channels {
+		n -= channel.DistributionWeight()
+		if n < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
+
+// pickUnfilled returns the first of the passed in channels which has fewer mapped URNs than its capacity, channels
+// without a capacity are never full
+func pickUnfilled(channels []*models.Channel, counts map[string]int) *models.Channel {
+	for _, channel := range channels {
+		if channel.Capacity == 0 || counts[channel.UUID] < channel.Capacity {
+			return channel
+		}
+	}
+	return nil
+}
+
+// pickHashed returns the channel the passed in URN hashes to, which is the channel with the highest score for it. As
+// each channel's score for a URN doesn't depend on the other channels, adding or removing a channel only moves the
+// URNs which it wins or had won.
+func pickHashed(channels []*models.Channel, urn string) *models.Channel {
+	var picked *models.Channel
+	best := math.Inf(-1)
+	for _, channel := range channels {
+		if score := hashScore(channel, urn); score > best {
+			picked, best = channel, score
+		}
+	}
+	return picked
+}
+
+// hashScore returns the weighted rendezvous score of a channel for a URN, which is chosen so that channels win a
+// share of URNs in proportion to their weights
+func hashScore(channel *models.Channel, urn string) float64 {
+	sum := sha256.Sum256([]byte(channel.UUID + "\n" + urn))
+
+	// take 53 bits of our hash as a number strictly between 0 and 1
+	u := (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+	return -float64(channel.DistributionWeight()) / math.Log(u)
+}
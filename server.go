@@ -6,29 +6,85 @@ import (
 	"bytes"
 	"compress/flate"
 	"context"
-	"crypto/subtle"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
-	"github.com/jmoiron/sqlx"
-	"github.com/nyaruka/rp-clover/migrations"
+	"github.com/nyaruka/rp-clover/models"
 )
 
+// the middleware applied to all our normal requests, compression and a maximum request time
+var standardMiddleware = []func(http.Handler) http.Handler{
+	middleware.Compress(flate.DefaultCompression),
+	middleware.Timeout(30 * time.Second),
+}
+
 // Server is a clover server, which handles incoming handle requests and configuration updates
 type Server struct {
 	config    *Config
 	router    *chi.Mux
 	server    *http.Server
-	db        *sqlx.DB
+	store     models.Store
 	waitGroup sync.WaitGroup
 	fs        http.FileSystem
+
+	// whether we are ready to receive traffic, false until started and once we start draining
+	ready atomic.Bool
+
+	// our in-flight forwards and background workers, and the context which tells workers to stop
+	work        *workTracker
+	workerCtx   context.Context
+	stopWorkers context.CancelFunc
+
+	// the networks whose forwarding headers we trust
+	trustedProxies []netip.Prefix
+
+	// where we keep the state of our interchange rate limits
+	limiter models.RateLimiter
+
+	// counts of incoming requests which failed verification, came from addresses not allowed, were over their rate
+	// limits or were duplicates, by interchange UUID
+	rejected   *counters
+	blocked    *counters
+	limited    *counters
+	duplicates *counters
+
+	// the clients we forward with and the breakers of the channels we forward to
+	clients  *clients
+	breakers *breakers
+
+	// counts of requests which failed over from a channel with an open breaker, or failed fast as there was no
+	// failover available, by channel UUID
+	failovers   *counters
+	unavailable *counters
+
+	// the health of the channels we check, and counts of how often it has changed by channel UUID
+	health        *healthStates
+	healthChanges *counters
+
+	// where we are up to for interchanges which distribute senders round robin
+	roundRobins *roundRobins
+
+	// counts of senders who overflowed from a channel at its capacity, or were refused as there was nowhere to
+	// overflow to, by channel UUID
+	overflows *counters
+	refusals  *counters
+
+	// counts of messages sent to the channel of a schedule as it was closed, by interchange UUID
+	closed *counters
+
+	// the interchanges our shared receive endpoint dispatches to, and counts of the requests to it which fell back
+	// to our configured interchange, by why they did
+	countries *countryIndex
+	fallbacks *counters
 }
 
 // NewServer creates a new clover server
@@ -36,29 +92,59 @@ func NewServer(config *Config, fs http.FileSystem) *Server {
 	server := &Server{
 		config: config,
 		fs:     fs,
+		work:   newWorkTracker(),
+
+		rejected:   newCounters(),
+		blocked:    newCounters(),
+		limited:    newCounters(),
+		duplicates: newCounters(),
+
+		clients:  newClients(),
+		breakers: newBreakers(),
+
+		failovers:   newCounters(),
+		unavailable: newCounters(),
+
+		health:        newHealthStates(),
+		healthChanges: newCounters(),
+
+		roundRobins: newRoundRobins(),
+
+		overflows: newCounters(),
+		refusals:  newCounters(),
+
+		closed: newCounters(),
+
+		countries: newCountryIndex(),
+		fallbacks: newCounters(),
 	}
+	server.workerCtx, server.stopWorkers = context.WithCancel(context.Background())
 
 	router := chi.NewRouter()
 	server.router = router
 
 	// global middleware
-	router.Use(middleware.Compress(flate.DefaultCompression))
 	router.Use(middleware.StripSlashes)
 	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
+	router.Use(server.realIP)
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.Timeout(30 * time.Second))
 
-	// mount our static files
-	workDir, _ := os.Getwd()
-	staticDir := filepath.Join(workDir, "./static")
-	server.addFileServer(router, "/", http.Dir(staticDir))
-
-	// and our admin views
+	// our admin views, these apply our standard middleware themselves as exports need to be able to stream
 	router.Mount("/admin", newAdminRouter(server))
 
-	// and our handler view
-	router.Mount("/i/{interchangeUUID:[0-9a-fA-F-]{36}}/receive", server.newHandlerFunc(handleInterchange))
+	router.Group(func(r chi.Router) {
+		r.Use(standardMiddleware...)
+
+		// mount our static files
+		workDir, _ := os.Getwd()
+		staticDir := filepath.Join(workDir, "./static")
+		server.addFileServer(r, "/", http.Dir(staticDir))
+
+		// and our handler view
+		r.Mount("/i/{interchangeUUID:[0-9a-fA-F-]{36}}/receive", server.newHandlerFunc(handleInterchange))
+		r.Mount("/receive", server.newHandlerFunc(handleSharedReceive))
+		r.Mount("/s/{scheme:[a-z]+}/receive", server.newHandlerFunc(handleSharedReceive))
+	})
 
 	return server
 }
@@ -68,29 +154,42 @@ func (s *Server) Start() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
-	db, err := sqlx.Open("postgres", s.config.DB)
+	trustedProxies, err := parseTrustedProxies(s.config.TrustedProxies)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid trusted proxies: %w", err)
 	}
-	db.SetMaxOpenConns(4)
-	s.db = db
+	s.trustedProxies = trustedProxies
 
-	err = s.db.PingContext(ctx)
+	// load our TLS certificate first so that misconfiguration fails fast
+	var certs *tlsLoader
+	if s.config.TLSCert != "" || s.config.TLSKey != "" {
+		certs, err = newTLSLoader(s.config.TLSCert, s.config.TLSKey, s.config.TLSClientCA)
+		if err != nil {
+			return err
+		}
+	} else if s.config.TLSClientCA != "" {
+		return fmt.Errorf("a TLS certificate and key must be configured to require client certificates")
+	}
+
+	store, err := models.OpenStore(ctx, s.config.DB)
 	if err != nil {
-		slog.Error("unable to ping database", "error", err)
 		return err
 	}
+	s.store = store
 
-	// migrate our db forward
-	err = migrations.Migrate(ctx, db)
+	s.limiter, err = s.newRateLimiter()
 	if err != nil {
+		s.store.Close()
 		return err
 	}
 
+	models.SetURNCacheSize(s.config.URNCacheSize)
+
 	// wire up our main pages
 	s.router.NotFound(s.handle404)
 	s.router.MethodNotAllowed(s.handle405)
 	s.router.Get("/", s.handleIndex)
+	s.router.Get("/ready", s.handleReady)
 
 	// configure timeouts on our server
 	s.server = &http.Server{
@@ -100,35 +199,80 @@ func (s *Server) Start() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// if we have a certificate we serve HTTPS, watching for it being renewed
+	if certs != nil {
+		s.server.TLSConfig = certs.config()
+		s.startWorker("tls-reload", certs.watch)
+	}
+
+	s.startPeriodicWorker("rate-limit-prune", rateLimitPruneInterval, s.limiter.PruneTokens)
+	s.startPeriodicWorker("dedup-prune", dedupPruneInterval, s.store.PruneDedupKeys)
+	s.startPeriodicWorker("health-prune", healthPruneInterval, s.pruneHealthChecks)
+
+	if s.config.HealthCheckInterval > 0 {
+		s.startPeriodicWorker("health-check", time.Duration(s.config.HealthCheckInterval)*time.Second, s.checkHealth)
+	}
+
 	s.waitGroup.Add(1)
 
 	// and start serving HTTP
 	go func() {
 		defer s.waitGroup.Done()
-		err := s.server.ListenAndServe()
+		var err error
+		if s.server.TLSConfig != nil {
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
 		if err != nil && err != http.ErrServerClosed {
 			slog.Error("http server error", "error", err)
 		}
 	}()
 
+	s.ready.Store(true)
+
 	slog.Info("clover started",
 		"address", s.config.Address,
 		"port", s.config.Port,
+		"tls", s.server.TLSConfig != nil,
 		"version", s.config.Version,
 	)
 
 	return nil
 }
 
-// Stop stops our clover server, returning any errors encountered
+// Stop drains and stops our clover server. We first report that we aren't ready so load balancers stop sending us
+// traffic, then stop accepting new requests and wait up to our drain timeout for in-flight requests, forwards and
+// background workers to finish before closing our store. Anything which didn't finish in time is logged.
 func (s *Server) Stop() error {
-	if err := s.server.Shutdown(context.Background()); err != nil {
-		slog.Error("error shutting down server", "error", err)
+	s.ready.Store(false)
+	slog.Info("clover draining", "delay", s.config.DrainDelay, "timeout", s.config.DrainTimeout)
+
+	if s.config.DrainDelay > 0 {
+		time.Sleep(time.Duration(s.config.DrainDelay) * time.Second)
 	}
 
-	// wait for everything to stop
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.config.DrainTimeout)*time.Second)
+	defer cancel()
+
+	// stop accepting requests and wait for in-flight ones, closing any connections still open at our deadline
+	if err := s.server.Shutdown(ctx); err != nil {
+		slog.Error("error shutting down server, closing remaining connections", "error", err)
+		s.server.Close()
+	}
 	s.waitGroup.Wait()
 
+	// tell our workers to stop and wait for them and any forwards still running
+	s.stopWorkers()
+	for kind, count := range s.work.wait(ctx) {
+		slog.Error("abandoned in-flight work", "kind", kind, "count", count)
+	}
+
+	err := s.store.Close()
+	if err != nil {
+		slog.Error("error closing store", "error", err)
+	}
+
 	slog.Info("clover stopped")
 	return nil
 }
@@ -157,6 +301,18 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf.Bytes())
 }
 
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if s.ready.Load() {
+		err = writeJSONResponse(r.Context(), w, http.StatusOK, map[string]string{"status": "ready"})
+	} else {
+		err = writeJSONResponse(r.Context(), w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+	}
+	if err != nil {
+		slog.Error("error writing ready response", "error", err)
+	}
+}
+
 func (s *Server) handle404(w http.ResponseWriter, r *http.Request) {
 	slog.Info("not found", "url", r.URL.String(), "method", r.Method, "resp_status", "404")
 	err := writeErrorResponse(r.Context(), w, http.StatusNotFound, "not found", fmt.Errorf("not found: %s", r.URL.String()))
@@ -173,19 +329,6 @@ func (s *Server) handle405(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) adminOnly(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, pass, ok := r.BasicAuth()
-		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte("admin")) != 1 || subtle.ConstantTimeCompare([]byte(pass), []byte(s.config.Password)) != 1 {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Clover"`)
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func (s *Server) addFileServer(r chi.Router, path string, root http.FileSystem) {
 	fs := http.StripPrefix(path, http.FileServer(root))
 	r.Get(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -3,10 +3,21 @@ package clover
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -16,7 +27,12 @@ import (
 )
 
 func setUpTest(t *testing.T) *Server {
+	return setUpTestWithDB(t, NewConfig().DB)
+}
+
+func setUpTestWithDB(t *testing.T, db string) *Server {
 	config := NewConfig()
+	config.DB = db
 	server := NewServer(config, http.Dir("static"))
 
 	err := server.Start()
@@ -28,6 +44,13 @@ func setUpTest(t *testing.T) *Server {
 }
 
 func makeTestRequest(path string, method string, values url.Values, authenticate bool, assertStatus int, assertBody string) (err error) {
+	if authenticate {
+		return makeTestRequestAs(path, method, values, "admin", "sesame123", assertStatus, assertBody)
+	}
+	return makeTestRequestAs(path, method, values, "", "", assertStatus, assertBody)
+}
+
+func makeTestRequestAs(path string, method string, values url.Values, username string, password string, assertStatus int, assertBody string) (err error) {
 	url := "http://localhost:8081" + path
 	var req *http.Request
 	if values == nil {
@@ -41,8 +64,8 @@ func makeTestRequest(path string, method string, values url.Values, authenticate
 		return err
 	}
 
-	if authenticate {
-		req.SetBasicAuth("admin", "sesame123")
+	if username != "" {
+		req.SetBasicAuth(username, password)
 	}
 
 	resp, err := http.DefaultClient.Do(req)
@@ -96,11 +119,13 @@ func TestEndpoints(t *testing.T) {
 		responseText string
 	}{
 		{"/", http.MethodGet, nil, false, 200, "Dev"},
+		{"/ready", http.MethodGet, nil, false, 200, `"status":"ready"`},
 		{"/admin", http.MethodGet, nil, false, 401, "Unauthorized"},
 		{"/admin", http.MethodGet, nil, true, 200, "Clover Configuration"},
 		{"/admin", http.MethodPost, url.Values{"config": []string{"arst"}}, true, 200, "invalid character"},
 		{"/admin", http.MethodPost, url.Values{"config": []string{testConfig}}, true, 200, "configuration saved"},
 		{"/admin", http.MethodPost, url.Values{"config": []string{"[]"}}, true, 200, "configuration saved"},
+		{"/admin/stats", http.MethodGet, nil, true, 200, `"urn_cache":{"capacity":100000`},
 		{"/foo", http.MethodGet, nil, false, 404, "not found"},
 	}
 
@@ -131,17 +156,23 @@ func TestMapping(t *testing.T) {
 		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212", http.MethodDelete, 200, "removed", "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", ""},
 		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212&channel=557d3353-6b89-441a-aee5-8c398fd7a61f", http.MethodPost, 200, "created", "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", "557d3353-6b89-441a-aee5-8c398fd7a61f"},
 		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212", http.MethodPost, 400, "channel not found", "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", "557d3353-6b89-441a-aee5-8c398fd7a61f"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212", http.MethodGet, 200, `"channel_name":"U-Report Nigeria"`, "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", "557d3353-6b89-441a-aee5-8c398fd7a61f"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/mappings?prefix=tel:%2B1206", http.MethodGet, 200, `"urn":"tel:+12065551212"`, "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", "557d3353-6b89-441a-aee5-8c398fd7a61f"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/mappings?prefix=tel:%2B1207", http.MethodGet, 200, `"mappings":[]`, "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", "557d3353-6b89-441a-aee5-8c398fd7a61f"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/mappings?limit=0", http.MethodGet, 400, "invalid limit", "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", "557d3353-6b89-441a-aee5-8c398fd7a61f"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/mappings/counts", http.MethodGet, 200, `"count":1`, "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", "557d3353-6b89-441a-aee5-8c398fd7a61f"},
 		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212", http.MethodDelete, 200, "removed", "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", ""},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212", http.MethodGet, 404, "mapping not found", "5fb66333-7f8c-47aa-9aa5-bfee37b79b22", "tel:+12065551212", ""},
 	}
 
 	for i, tc := range tcs {
 		err := makeTestRequest(tc.path, tc.method, nil, true, tc.assertStatus, tc.assertText)
 		assert.NoError(t, err, "test %d: error making request", i)
 
-		interchange, err := models.GetInterchange(context.Background(), s.db, tc.assertInterchange)
+		interchange, err := s.store.GetInterchange(context.Background(), tc.assertInterchange)
 		assert.NoError(t, err, "test %d: error looking up interchange", i)
 
-		channel, err := models.GetChannelForURN(context.Background(), s.db, interchange, tc.assertURN)
+		channel, err := s.store.GetChannelForURN(context.Background(), interchange, tc.assertURN)
 		assert.NoError(t, err, "test %d: error looking up channel", i)
 
 		if tc.assertChannelUUID == "" {
@@ -151,3 +182,358 @@ func TestMapping(t *testing.T) {
 		}
 	}
 }
+
+func TestExport(t *testing.T) {
+	s := setUpTest(t)
+	defer s.Stop()
+
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{testConfig}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	err = makeTestRequest("/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212&channel=557d3353-6b89-441a-aee5-8c398fd7a61f", http.MethodPost, nil, true, 200, "created")
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		path         string
+		authenticate bool
+		responseCode int
+		responseText string
+	}{
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/export", false, 401, "Unauthorized"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b11/export", true, 404, "interchange not found"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/export?format=xml", true, 400, "invalid format"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/export?channel=3d0cd397-2228-4185-86db-7e3272fc423e", true, 400, "channel not found"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/export", true, 200, "urn,interchange_uuid,channel_uuid,created_on,modified_on\ntel:+12065551212,5fb66333-7f8c-47aa-9aa5-bfee37b79b22,557d3353-6b89-441a-aee5-8c398fd7a61f,"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/export?format=jsonl&channel=557d3353-6b89-441a-aee5-8c398fd7a61f", true, 200, `{"urn":"tel:+12065551212","interchange_uuid":"5fb66333-7f8c-47aa-9aa5-bfee37b79b22","channel_uuid":"557d3353-6b89-441a-aee5-8c398fd7a61f","created_on":`},
+	}
+
+	for i, tc := range tcs {
+		err := makeTestRequest(tc.path, http.MethodGet, nil, tc.authenticate, tc.responseCode, tc.responseText)
+		assert.NoErrorf(t, err, "test %d: error making request", i)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	s := setUpTest(t)
+	defer s.Stop()
+
+	users, err := s.store.GetUsers(context.Background())
+	assert.NoError(t, err)
+	for _, user := range users {
+		_, err = s.store.DeleteUser(context.Background(), user.Username)
+		assert.NoError(t, err)
+	}
+
+	tcs := []struct {
+		path         string
+		method       string
+		body         url.Values
+		username     string
+		password     string
+		responseCode int
+		responseText string
+	}{
+		{"/admin/users", http.MethodPost, url.Values{"username": {"bob"}, "password": {"short"}, "role": {"viewer"}}, "admin", "sesame123", 400, "at least 8 characters"},
+		{"/admin/users", http.MethodPost, url.Values{"username": {"bob"}, "password": {"bobsecret"}, "role": {"boss"}}, "admin", "sesame123", 400, "invalid user"},
+		{"/admin/users", http.MethodPost, url.Values{"username": {"admin"}, "password": {"bobsecret"}, "role": {"viewer"}}, "admin", "sesame123", 400, "reserved"},
+		{"/admin/users", http.MethodPost, url.Values{"username": {"Admin"}, "password": {"bobsecret"}, "role": {"viewer"}}, "admin", "sesame123", 400, "reserved"},
+		{"/admin/users", http.MethodPost, url.Values{"username": {"bob"}, "role": {"viewer"}}, "admin", "sesame123", 400, "password is required for new users"},
+		{"/admin/users", http.MethodPost, url.Values{"username": {"bob"}, "password": {"bobsecret"}, "role": {"viewer"}}, "admin", "sesame123", 200, `"role":"viewer"`},
+		{"/admin/users", http.MethodPost, url.Values{"username": {"ann"}, "password": {"annsecret"}, "role": {"viewer"}}, "admin", "sesame123", 200, `"role":"viewer"`},
+		{"/admin/users", http.MethodPost, url.Values{"username": {"ann"}, "role": {"operator"}}, "admin", "sesame123", 200, `"role":"operator"`},
+		{"/admin/users", http.MethodGet, nil, "admin", "sesame123", 200, `"username":"ann"`},
+
+		// viewers can only read config and mappings
+		{"/admin", http.MethodGet, nil, "bob", "wrong", 401, "Unauthorized"},
+		{"/admin", http.MethodGet, nil, "bob", "bobsecret", 200, "Clover Configuration"},
+		{"/admin", http.MethodPost, url.Values{"config": []string{testConfig}}, "bob", "bobsecret", 403, "missing permission: config:write"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212", http.MethodDelete, nil, "bob", "bobsecret", 403, "missing permission: mappings:write"},
+		{"/admin/users", http.MethodGet, nil, "bob", "bobsecret", 403, "missing permission: users:write"},
+
+		// operators can also manage mappings
+		{"/admin", http.MethodPost, url.Values{"config": []string{testConfig}}, "ann", "annsecret", 403, "missing permission: config:write"},
+		{"/admin", http.MethodPost, url.Values{"config": []string{testConfig}}, "admin", "sesame123", 200, "configuration saved"},
+		{"/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212", http.MethodDelete, nil, "ann", "annsecret", 200, "mapping removed"},
+
+		{"/admin/users/bob", http.MethodDelete, nil, "ann", "annsecret", 403, "missing permission: users:write"},
+		{"/admin/users/bob", http.MethodDelete, nil, "admin", "sesame123", 200, "user deleted"},
+		{"/admin/users/bob", http.MethodDelete, nil, "admin", "sesame123", 404, "user not found"},
+		{"/admin", http.MethodGet, nil, "bob", "bobsecret", 401, "Unauthorized"},
+	}
+
+	for i, tc := range tcs {
+		err := makeTestRequestAs(tc.path, tc.method, tc.body, tc.username, tc.password, tc.responseCode, tc.responseText)
+		assert.NoErrorf(t, err, "test %d: error making request", i)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	s := setUpTest(t)
+	defer s.Stop()
+
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{testConfig}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	err = makeTestRequest("/admin/tokens", http.MethodPost, url.Values{"name": {"flows"}, "permission": {"users:write"}}, true, 400, "invalid token permission")
+	assert.NoError(t, err)
+
+	// create a token limited to managing mappings on our interchange
+	form := url.Values{"name": {"flows"}, "permission": {"mappings:write"}, "interchange": {"5fb66333-7f8c-47aa-9aa5-bfee37b79b22"}}
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:8081/admin/tokens", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("admin", "sesame123")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	created := &struct {
+		Data struct {
+			ID    int    `json:"id"`
+			Token string `json:"token"`
+		} `json:"data"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(created)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Data.Token, "clv_"))
+
+	makeTokenRequest := func(path string, method string, token string) int {
+		req, _ := http.NewRequest(method, "http://localhost:8081"+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	mapPath := "/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065551212&channel=557d3353-6b89-441a-aee5-8c398fd7a61f"
+	assert.Equal(t, 401, makeTokenRequest(mapPath, http.MethodPost, "clv_invalid"))
+	assert.Equal(t, 200, makeTokenRequest(mapPath, http.MethodPost, created.Data.Token))
+	assert.Equal(t, 200, makeTokenRequest(mapPath, http.MethodDelete, created.Data.Token))
+	assert.Equal(t, 403, makeTokenRequest(mapPath, http.MethodGet, created.Data.Token))
+	assert.Equal(t, 403, makeTokenRequest("/admin/afc2532c-1565-4016-a83e-fc6bc1ac3550/map?urn=tel:%2B12065551212", http.MethodDelete, created.Data.Token))
+	assert.Equal(t, 403, makeTokenRequest("/admin", http.MethodGet, created.Data.Token))
+
+	err = makeTestRequest("/admin/tokens", http.MethodGet, nil, true, 200, `"last_used_on":"`)
+	assert.NoError(t, err)
+
+	err = makeTestRequest(fmt.Sprintf("/admin/tokens/%d", created.Data.ID), http.MethodDelete, nil, true, 200, "token revoked")
+	assert.NoError(t, err)
+
+	err = makeTestRequest(fmt.Sprintf("/admin/tokens/%d", created.Data.ID), http.MethodDelete, nil, true, 404, "token not found")
+	assert.NoError(t, err)
+
+	// our audit log records the token as it was before and after being revoked
+	err = makeTestRequest("/admin/audit/export?format=jsonl&action=token.revoke", http.MethodGet, nil, true, 200, fmt.Sprintf(`"before":{"id":%d,"name":"flows"`, created.Data.ID))
+	assert.NoError(t, err)
+	err = makeTestRequest("/admin/audit/export?format=jsonl&action=token.revoke", http.MethodGet, nil, true, 200, fmt.Sprintf(`"revoked_on":null},"after":{"id":%d,"name":"flows"`, created.Data.ID))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 401, makeTokenRequest(mapPath, http.MethodPost, created.Data.Token))
+}
+
+func TestAudit(t *testing.T) {
+	s := setUpTest(t)
+	defer s.Stop()
+
+	err := makeTestRequest("/admin", http.MethodPost, url.Values{"config": []string{testConfig}}, true, 200, "configuration saved")
+	assert.NoError(t, err)
+
+	err = makeTestRequest("/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065559876&channel=557d3353-6b89-441a-aee5-8c398fd7a61f", http.MethodPost, nil, true, 200, "created")
+	assert.NoError(t, err)
+
+	err = makeTestRequest("/admin/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/map?urn=tel:%2B12065559876", http.MethodDelete, nil, true, 200, "removed")
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		path         string
+		responseCode int
+		responseText string
+	}{
+		{"/admin/audit", 200, "interchanges/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/tel:&#43;12065559876"},
+		{"/admin/audit?action=mapping.clear", 200, "mapping.clear"},
+		{"/admin/audit?since=yesterday", 400, "since must be an RFC3339 timestamp"},
+		{"/admin/audit/export?action=config.update", 200, "config.update"},
+		{"/admin/audit/export?format=jsonl&target=interchanges/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/tel:%2B12065559876", 200, `"action":"mapping.clear","target":"interchanges/5fb66333-7f8c-47aa-9aa5-bfee37b79b22/tel:+12065559876","before":{"urn":"tel:+12065559876"`},
+	}
+
+	for i, tc := range tcs {
+		err := makeTestRequest(tc.path, http.MethodGet, nil, true, tc.responseCode, tc.responseText)
+		assert.NoErrorf(t, err, "test %d: error making request", i)
+	}
+}
+
+// writes a new certificate for localhost, signed by the passed in CA or self-signed if that is nil
+func writeTestCert(t *testing.T, certFile, keyFile string, name string, isCA bool, ca *tls.Certificate) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	parent, signer := template, any(key)
+	if ca != nil {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	assert.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	assert.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return &cert
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := dir+"/cert.pem", dir+"/key.pem", dir+"/ca.pem"
+
+	defer func(interval time.Duration) { tlsReloadInterval = interval }(tlsReloadInterval)
+	tlsReloadInterval = 10 * time.Millisecond
+
+	ca := writeTestCert(t, caFile, dir+"/ca-key.pem", "Test CA", true, nil)
+	writeTestCert(t, certFile, keyFile, "clover", false, ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	config := NewConfig()
+	config.DB = "memory:"
+	config.Port = 8082
+
+	// can't require client certificates without a certificate of our own
+	config.TLSClientCA = caFile
+	err := NewServer(config, http.Dir("static")).Start()
+	assert.EqualError(t, err, "a TLS certificate and key must be configured to require client certificates")
+
+	config.TLSCert = certFile
+	config.TLSKey = dir + "/missing.pem"
+	err = NewServer(config, http.Dir("static")).Start()
+	assert.ErrorContains(t, err, "missing.pem")
+
+	config.TLSKey = keyFile
+	s := NewServer(config, http.Dir("static"))
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	get := func(clientCert *tls.Certificate) (string, error) {
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if clientCert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*clientCert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get("https://localhost:8082/ready")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	// clients without a certificate are refused
+	_, err = get(nil)
+	assert.Error(t, err)
+
+	// as are those with a certificate not signed by our CA
+	other := writeTestCert(t, dir+"/other.pem", dir+"/other-key.pem", "other", false, nil)
+	_, err = get(other)
+	assert.Error(t, err)
+
+	client := writeTestCert(t, dir+"/client.pem", dir+"/client-key.pem", "client", false, ca)
+	name, err := get(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "clover", name)
+
+	// renewing our certificate is picked up without a restart
+	writeTestCert(t, certFile, keyFile, "clover-renewed", false, ca)
+	renewed := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(certFile, renewed, renewed))
+	time.Sleep(100 * time.Millisecond)
+
+	name, err = get(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "clover-renewed", name)
+}
+
+func TestRealIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.1, 192.168.0.0/16")
+	assert.NoError(t, err)
+
+	_, err = parseTrustedProxies("10.0.0.1,foo")
+	assert.EqualError(t, err, "invalid network 'foo'")
+
+	s := &Server{config: NewConfig(), trustedProxies: proxies}
+	trusting := &Server{config: NewConfig(), trustedProxies: proxies}
+	trusting.config.TrustRealIP = true
+
+	tcs := []struct {
+		server       *Server
+		remoteAddr   string
+		realIP       string
+		forwardedFor string
+		expected     string
+	}{
+		{s, "1.2.3.4:1234", "", "", "1.2.3.4:1234"},
+		{s, "1.2.3.4:1234", "5.6.7.8", "", "1.2.3.4:1234"},
+		{s, "1.2.3.4:1234", "", "5.6.7.8", "1.2.3.4:1234"},
+		{s, "10.0.0.1:1234", "", "5.6.7.8", "5.6.7.8"},
+		{s, "10.0.0.1:1234", "", "9.9.9.9, 5.6.7.8", "5.6.7.8"},
+		{s, "10.0.0.1:1234", "", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		{s, "10.0.0.1:1234", "", "192.168.1.2, 192.168.1.1", "192.168.1.2"},
+		{s, "10.0.0.2:1234", "", "5.6.7.8", "10.0.0.2:1234"},
+
+		// a client's X-Real-IP passed through by our proxy is ignored unless we trust our proxies to set it
+		{s, "10.0.0.1:1234", "1.2.3.4", "5.6.7.8", "5.6.7.8"},
+		{s, "10.0.0.1:1234", "1.2.3.4", "", "10.0.0.1:1234"},
+		{trusting, "10.0.0.1:1234", "5.6.7.8", "9.9.9.9", "5.6.7.8"},
+		{trusting, "10.0.0.1:1234", "", "9.9.9.9", "9.9.9.9"},
+		{trusting, "1.2.3.4:1234", "5.6.7.8", "", "1.2.3.4:1234"},
+	}
+
+	for i, tc := range tcs {
+		var remoteAddr string
+		handler := tc.server.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remoteAddr = r.RemoteAddr
+		}))
+
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if tc.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Equal(t, tc.expected, remoteAddr, "%d: remote address mismatch", i)
+	}
+}
+
+func TestRateLimiterConfig(t *testing.T) {
+	config := NewConfig()
+	config.DB = "memory:"
+	config.RateLimiter = "redis"
+	err := NewServer(config, http.Dir("static")).Start()
+	assert.EqualError(t, err, "unknown rate limiter 'redis', must be one of store or memory")
+
+	config.RateLimiter = "memory"
+	s := NewServer(config, http.Dir("static"))
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	assert.IsType(t, &models.MemoryRateLimiter{}, s.limiter)
+}
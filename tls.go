@@ -0,0 +1,154 @@
+package clover
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// how often we check whether our certificate, key or client CA files have changed
+var tlsReloadInterval = 10 * time.Second
+
+// tlsLoader loads our certificate and optional client CA bundle from disk, reloading them when the files change
+// so that renewed certificates are picked up without a restart
+type tlsLoader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+func newTLSLoader(certFile, keyFile, clientCAFile string) (*tlsLoader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both a TLS certificate and key must be configured")
+	}
+
+	l := &tlsLoader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// load reads our files from disk, only replacing our current certificate if they are all valid
+func (l *tlsLoader) load() error {
+	modTimes, err := l.currentModTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if l.clientCAFile != "" {
+		pem, err := os.ReadFile(l.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("error reading client CA bundle: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA bundle %s", l.clientCAFile)
+		}
+	}
+
+	l.mutex.Lock()
+	l.cert = &cert
+	l.clientCAs = clientCAs
+	l.modTimes = modTimes
+	l.mutex.Unlock()
+
+	return nil
+}
+
+func (l *tlsLoader) currentModTimes() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time, 3)
+	for _, file := range []string{l.certFile, l.keyFile, l.clientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// reloadIfChanged reloads our files if any of them have been modified since we last loaded them. Errors leave us
+// serving our previous certificate, as a renewal may be partway through writing the files.
+func (l *tlsLoader) reloadIfChanged() {
+	modTimes, err := l.currentModTimes()
+	if err != nil {
+		slog.Error("error checking TLS files", "error", err)
+		return
+	}
+
+	l.mutex.RLock()
+	changed := false
+	for file, modTime := range modTimes {
+		if !modTime.Equal(l.modTimes[file]) {
+			changed = true
+		}
+	}
+	l.mutex.RUnlock()
+
+	if !changed {
+		return
+	}
+
+	if err := l.load(); err != nil {
+		slog.Error("error reloading TLS files, continuing with previous certificate", "error", err)
+		return
+	}
+	slog.Info("reloaded TLS certificate", "cert", l.certFile, "client_ca", l.clientCAFile)
+}
+
+// watch checks for changes to our files until the passed in context is done
+func (l *tlsLoader) watch(ctx context.Context) {
+	ticker := time.NewTicker(tlsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.reloadIfChanged()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// config returns the TLS config for our server, requiring client certificates signed by our client CA bundle if
+// we have one. Each handshake gets the certificate and CAs which are current at that time.
+func (l *tlsLoader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			l.mutex.RLock()
+			defer l.mutex.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*l.cert},
+			}
+			if l.clientCAs != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = l.clientCAs
+			}
+			return config, nil
+		},
+	}
+}
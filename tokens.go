@@ -0,0 +1,74 @@
+package clover
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// createdToken is the response to creating a token, the only time the token value is ever returned
+type createdToken struct {
+	*models.APIToken
+	Token string `json:"token"`
+}
+
+// handles a request to list our API tokens
+func handleListTokens(s *Server, w http.ResponseWriter, r *http.Request) error {
+	tokens, err := s.store.GetAPITokens(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return writeDataResponse(r.Context(), w, http.StatusOK, "tokens", tokens)
+}
+
+// handles a request to create a new API token
+func handleCreateToken(s *Server, w http.ResponseWriter, r *http.Request) error {
+	r.ParseForm()
+
+	permissions := make([]models.Permission, len(r.Form["permission"]))
+	for i, perm := range r.Form["permission"] {
+		permissions[i] = models.Permission(perm)
+	}
+
+	token, value, err := s.store.CreateAPIToken(r.Context(), r.Form.Get("name"), permissions, r.Form["interchange"], principalFromContext(r.Context()).name)
+	if err != nil {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid token", err)
+	}
+
+	s.recordAudit(r, models.AuditTokenCreate, fmt.Sprintf("tokens/%d", token.ID), nil, token)
+	return writeDataResponse(r.Context(), w, http.StatusOK, "token created", &createdToken{token, value})
+}
+
+// handles a request to revoke an API token
+func handleRevokeToken(s *Server, w http.ResponseWriter, r *http.Request) error {
+	id, _ := strconv.Atoi(chi.URLParam(r, "tokenID"))
+
+	before, err := s.store.GetAPIToken(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	revoked := false
+	if before != nil {
+		revoked, err = s.store.RevokeAPIToken(r.Context(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !revoked {
+		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "token not found", fmt.Errorf("no active token with id: %d", id))
+	}
+
+	after, err := s.store.GetAPIToken(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	s.recordAudit(r, models.AuditTokenRevoke, fmt.Sprintf("tokens/%d", id), before, after)
+	return writeDataResponse(r.Context(), w, http.StatusOK, "token revoked", nil)
+}
@@ -0,0 +1,83 @@
+package clover
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/rp-clover/models"
+)
+
+// handles a request to list our admin users
+func handleListUsers(s *Server, w http.ResponseWriter, r *http.Request) error {
+	users, err := s.store.GetUsers(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return writeDataResponse(r.Context(), w, http.StatusOK, "users", users)
+}
+
+// handles a request to create a user or update their password and role, leaving out the password only updates the
+// role of an existing user
+func handleSaveUser(s *Server, w http.ResponseWriter, r *http.Request) error {
+	r.ParseForm()
+	username := r.Form.Get("username")
+	password := r.Form.Get("password")
+	role := models.Role(r.Form.Get("role"))
+
+	if strings.EqualFold(username, builtinAdmin) && s.config.Password != "" {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid username", fmt.Errorf("%s is reserved for the built-in admin user", builtinAdmin))
+	}
+
+	previous, err := s.store.GetUser(r.Context(), username)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.SaveUser(r.Context(), username, password, role)
+	if err != nil {
+		return writeErrorResponse(r.Context(), w, http.StatusBadRequest, "invalid user", err)
+	}
+
+	user, err := s.store.GetUser(r.Context(), username)
+	if err != nil {
+		return err
+	}
+
+	s.recordAudit(r, models.AuditUserSave, "users/"+username, auditUser(previous), user)
+
+	return writeDataResponse(r.Context(), w, http.StatusOK, "user saved", user)
+}
+
+// handles a request to delete a user
+func handleDeleteUser(s *Server, w http.ResponseWriter, r *http.Request) error {
+	username := chi.URLParam(r, "username")
+
+	previous, err := s.store.GetUser(r.Context(), username)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.store.DeleteUser(r.Context(), username)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return writeErrorResponse(r.Context(), w, http.StatusNotFound, "user not found", fmt.Errorf("no user with username: %s", username))
+	}
+
+	s.recordAudit(r, models.AuditUserDelete, "users/"+username, auditUser(previous), nil)
+
+	return writeDataResponse(r.Context(), w, http.StatusOK, "user deleted", nil)
+}
+
+// auditUser returns the passed in user as an audit value, this makes sure a missing user is recorded as null
+func auditUser(user *models.User) interface{} {
+	if user == nil {
+		return nil
+	}
+	return user
+}
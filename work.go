@@ -0,0 +1,110 @@
+package clover
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// the kinds of work we track
+const (
+	workForward = "forward"
+)
+
+// workTracker tracks in-flight work by kind so that we can wait for it to finish when stopping and report what
+// didn't finish in time
+type workTracker struct {
+	mutex    sync.Mutex
+	inFlight map[string]int
+	total    int
+
+	// closed whenever we have no work in flight
+	idle chan struct{}
+}
+
+func newWorkTracker() *workTracker {
+	idle := make(chan struct{})
+	close(idle)
+	return &workTracker{inFlight: make(map[string]int), idle: idle}
+}
+
+// begin records the start of some work of the passed in kind, the returned function must be called when it is done
+func (t *workTracker) begin(kind string) func() {
+	t.mutex.Lock()
+	if t.total == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.inFlight[kind]++
+	t.total++
+	t.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mutex.Lock()
+			t.inFlight[kind]--
+			t.total--
+			if t.total == 0 {
+				close(t.idle)
+			}
+			t.mutex.Unlock()
+		})
+	}
+}
+
+// wait waits for all in-flight work to finish or for the passed in context to be done, returning the amount of
+// work of each kind still in flight
+func (t *workTracker) wait(ctx context.Context) map[string]int {
+	t.mutex.Lock()
+	idle := t.idle
+	t.mutex.Unlock()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	remaining := make(map[string]int)
+	for kind, count := range t.inFlight {
+		if count > 0 {
+			remaining[kind] = count
+		}
+	}
+	return remaining
+}
+
+// startWorker runs the passed in function in the background until it returns. The context passed to it is
+// cancelled when we start draining and we wait for it to return before closing our store.
+func (s *Server) startWorker(name string, fn func(ctx context.Context)) {
+	done := s.work.begin(name)
+
+	go func() {
+		defer done()
+		fn(s.workerCtx)
+		slog.Debug("worker stopped", "worker", name)
+	}()
+}
+
+// startPeriodicWorker runs the passed in function every interval in the background until we start draining, errors
+// are logged and don't stop the worker
+func (s *Server) startPeriodicWorker(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	s.startWorker(name, func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := fn(ctx); err != nil {
+					slog.Error("error running worker", "worker", name, "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}